@@ -0,0 +1,300 @@
+package generatetoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// batchEntry is an element of the -batch-file array.
+type batchEntry struct {
+	// Name keys the entry in the output; it defaults to "<app_id>/<repo>".
+	Name        string            `json:"name"`
+	AppID       int64             `json:"app_id"`
+	PrivateKey  string            `json:"private_key"`
+	Repo        string            `json:"repo"`
+	Permissions map[string]string `json:"permissions"`
+}
+
+func (e *batchEntry) key() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return strconv.FormatInt(e.AppID, 10) + "/" + e.Repo
+}
+
+func (e *batchEntry) validate() error {
+	if e.AppID == 0 {
+		return errors.New("app_id is required")
+	}
+	if e.PrivateKey == "" {
+		return errors.New("private_key is required")
+	}
+	if _, err := newKeySource(e.PrivateKey, nil); err != nil {
+		return fmt.Errorf("invalid private_key: %w", err)
+	}
+	if e.Repo == "" {
+		return errors.New("repo is required")
+	}
+	for name, level := range e.Permissions {
+		if !permissionLevels[level] {
+			return fmt.Errorf("unknown permission level %q for %s", level, name)
+		}
+	}
+	return nil
+}
+
+// batchResult is the value printed for each entry: the minted token or the error that prevented it.
+type batchResult struct {
+	// Name is only set on -format jsonl lines; the JSON object keys the results by name instead.
+	Name string `json:"name,omitempty"`
+	*tokenOutput
+	ExpiresAt interface{} `json:"expires_at,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+func loadBatchEntries(path string) ([]*batchEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", path, err)
+	}
+	var entries []*batchEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, e := range entries {
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("%s: entry #%d: %w", path, i, err)
+		}
+		if seen[e.key()] {
+			return nil, fmt.Errorf("%s: entry #%d: duplicate name %s", path, i, e.key())
+		}
+		seen[e.key()] = true
+	}
+	return entries, nil
+}
+
+// forEntry returns a copy of the Generator configured for the entry.
+// Settings that are not part of an entry, such as -base-url, -liveness and -permission-profile, are inherited,
+// and so are the -permission flags unless the entry lists its own permissions.
+func (g *Generator) forEntry(e *batchEntry) *Generator {
+	c := *g
+	c.appID = e.AppID
+	c.clientID = ""
+	// validate has rejected private keys newKeySource cannot read.
+	source, _ := newKeySource(e.PrivateKey, c.keyFetchClient)
+	c.privateKeys = keySources{source}
+	c.installedRepository = e.Repo
+	c.account = ""
+	if e.Permissions != nil {
+		c.permissions = permissionsFlag(e.Permissions)
+	}
+	return &c
+}
+
+// defaultConcurrency is the default of -concurrency, which bounds the tokens the batch modes mint at the same time so that large fleets do not trip secondary rate limits.
+const defaultConcurrency = 4
+
+// runBatch mints an installation token for every -batch-file entry concurrently, at most -concurrency at a time, and prints a JSON object keyed by entry name.
+// A failing entry is reported in its value and does not stop the others unless -fail-fast is set.
+func (g *Generator) runBatch(ctx context.Context) error {
+	entries, err := loadBatchEntries(g.batchFile)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, g.concurrency)
+		results  = map[string]*batchResult{}
+		failed   int
+		firstErr error
+	)
+	for _, e := range entries {
+		e := e
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			minted, err := g.forEntry(e).generateInstallationToken(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", e.key(), err)
+				}
+				if g.failFast {
+					cancel()
+				}
+				results[e.key()] = &batchResult{Error: err.Error()}
+			} else {
+				out := minted.output()
+				results[e.key()] = &batchResult{tokenOutput: out, ExpiresAt: g.formatExpiry(out.ExpiresAt)}
+			}
+			if g.outputFormat == formatJSONLines {
+				line := *results[e.key()]
+				line.Name = e.key()
+				g.printLine(&line)
+			}
+		}()
+	}
+	wg.Wait()
+	if g.failFast && firstErr != nil {
+		return firstErr
+	}
+	if g.outputFormat == formatJSONLines {
+		if failed > 0 {
+			return fmt.Errorf("%d of %d batch entries failed", failed, len(entries))
+		}
+		return nil
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	fmt.Fprintln(g.outStream, string(b))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d batch entries failed", failed, len(entries))
+	}
+	return nil
+}
+
+// installationResult is an element of the -all-installations output.
+// With -out-file-template the token is written to OutFile instead and left out of the output.
+type installationResult struct {
+	InstallationID int64  `json:"installation_id"`
+	AccountLogin   string `json:"account_login"`
+	OutFile        string `json:"out_file,omitempty"`
+	*batchResult
+}
+
+// listInstallations pages through every installation of the App.
+func listInstallations(ctx context.Context, client *github.Client) ([]*github.Installation, error) {
+	var installations []*github.Installation
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Apps.ListInstallations(): %w", classifyAPIError(err))
+		}
+		installations = append(installations, page...)
+		if resp.NextPage == 0 {
+			return installations, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// runAllInstallations mints an installation token for every installation of the App with the same -permission and -scope-repo settings,
+// at most -concurrency at a time, and prints a JSON array ordered as GitHub lists the installations. Failures are reported per installation like -batch-file.
+// Each mint signs an app JWT of its own, as a large fleet takes longer than -liveness to go through.
+func (g *Generator) runAllInstallations(ctx context.Context) error {
+	client, err := g.appClient(ctx)
+	if err != nil {
+		return err
+	}
+	installations, err := listInstallations(ctx, client)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if g.outFileTemplate != "" {
+		tmpl, err := parseOutFileTemplate(g.outFileTemplate)
+		if err != nil {
+			return err
+		}
+		if paths, err = outFilePaths(tmpl, installations); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, g.concurrency)
+		results  = make([]*installationResult, len(installations))
+		failed   int
+		firstErr error
+	)
+	for i, installation := range installations {
+		i, installation := i, installation
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result := &installationResult{InstallationID: installation.GetID(), AccountLogin: installation.GetAccount().GetLogin()}
+			var minted *installationToken
+			client, err := g.appClient(ctx)
+			if err == nil {
+				minted, err = g.mintInstallationToken(ctx, client, installation)
+			}
+			if err == nil && paths != nil {
+				result.OutFile = paths[i]
+				err = writeTokenFile(paths[i], minted.token.GetToken())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("installation %d: %w", installation.GetID(), err)
+				}
+				if g.failFast {
+					cancel()
+				}
+				result.batchResult = &batchResult{Error: err.Error()}
+			} else {
+				out := minted.output()
+				if paths != nil {
+					out.Token = ""
+				}
+				result.batchResult = &batchResult{tokenOutput: out, ExpiresAt: g.formatExpiry(out.ExpiresAt)}
+			}
+			if g.outputFormat == formatJSONLines {
+				g.printLine(result)
+			}
+		}()
+	}
+	wg.Wait()
+	if g.failFast && firstErr != nil {
+		return firstErr
+	}
+	if g.outputFormat == formatJSONLines {
+		if failed > 0 {
+			return fmt.Errorf("%d of %d installations failed", failed, len(installations))
+		}
+		return nil
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	fmt.Fprintln(g.outStream, string(b))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d installations failed", failed, len(installations))
+	}
+	return nil
+}
+
+// printLine prints v as a -format jsonl line; callers hold the lock serializing the results so that lines never interleave.
+func (g *Generator) printLine(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	fmt.Fprintln(g.outStream, string(b))
+}
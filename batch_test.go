@@ -0,0 +1,78 @@
+package generatetoken
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+func TestConcurrencyBoundsInFlightMints(t *testing.T) {
+	const fleet = 8
+	cases := []struct {
+		name        string
+		concurrency int
+		batch       bool
+	}{
+		{name: "-all-installations one at a time", concurrency: 1},
+		{name: "-all-installations two at a time", concurrency: 2},
+		{name: "-all-installations above the fleet", concurrency: fleet * 2},
+		{name: "-batch-file one at a time", concurrency: 1, batch: true},
+		{name: "-batch-file three at a time", concurrency: 3, batch: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			m.mintDelay = 20 * time.Millisecond
+			args := []string{"generate-github-app-token", "-base-url", m.URL + "/", "-concurrency", strconv.Itoa(tc.concurrency)}
+			if tc.batch {
+				args = append(args, "-batch-file", writeBatchFile(t, fleet, "acme/api"))
+			} else {
+				for i := 0; i < fleet; i++ {
+					m.installations = append(m.installations, &github.Installation{
+						ID:      github.Int64(int64(100 + i)),
+						Account: &github.User{Login: github.String(fmt.Sprintf("org%d", i)), Type: github.String("Organization")},
+					})
+				}
+				args = append(args, "-id", "123", "-private-key", testKeyFile, "-all-installations")
+			}
+			if code, _, stderr := runCLI(args); code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.mints != fleet {
+				t.Errorf("%d tokens minted, want %d", m.mints, fleet)
+			}
+			want := tc.concurrency
+			if want > fleet {
+				want = fleet
+			}
+			if m.maxInFlight > want {
+				t.Errorf("%d mints in flight at once, want at most %d", m.maxInFlight, want)
+			}
+			if m.maxInFlight == 0 {
+				t.Error("no mint was in flight")
+			}
+		})
+	}
+}
+
+// writeBatchFile writes a -batch-file of n entries named e0, e1, ... minting for repo as App 123 with the test key, and returns its path.
+func writeBatchFile(t *testing.T, n int, repo string) string {
+	t.Helper()
+	entries := make([]string, n)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"name": "e%d", "app_id": 123, "private_key": %q, "repo": %q}`, i, testKeyFile, repo)
+	}
+	path := filepath.Join(t.TempDir(), "batch.json")
+	if err := ioutil.WriteFile(path, []byte("["+strings.Join(entries, ",")+"]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,58 @@
+package generatetoken
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+func validateColorMode(mode string) error {
+	switch mode {
+	case colorAuto, colorAlways, colorNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown -color: %s", mode)
+	}
+}
+
+type severity string
+
+const (
+	severityInfo    severity = "\x1b[36m"
+	severityWarning severity = "\x1b[33m"
+	severityError   severity = "\x1b[31m"
+)
+
+// colorize wraps msg in the ANSI color of the severity when diagnostics written to w should be colored.
+// The token is never passed here: only diagnostics on errStream or the log file are colored.
+func (g *Generator) colorize(w io.Writer, sev severity, msg string) string {
+	if !g.shouldColor(w) {
+		return msg
+	}
+	return string(sev) + msg + "\x1b[0m"
+}
+
+// shouldColor follows -color; in auto mode it colors only when NO_COLOR is unset and w is a terminal.
+func (g *Generator) shouldColor(w io.Writer) bool {
+	switch g.colorMode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
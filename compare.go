@@ -0,0 +1,208 @@
+package generatetoken
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// compareConfig is the document read by -compare-with. Every field is optional; the ones left out are taken from the current flags.
+//
+//	{"app_id": 123, "private_key": "/path/to/key.pem", "repo": "owner/name", "permissions": {"contents": "read"}, "repositories": ["name"]}
+type compareConfig struct {
+	AppID        int64             `json:"app_id"`
+	PrivateKey   string            `json:"private_key"`
+	Repo         string            `json:"repo"`
+	Account      string            `json:"account"`
+	Permissions  map[string]string `json:"permissions"`
+	Repositories []string          `json:"repositories"`
+}
+
+func loadCompareConfig(path string) (*compareConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var cfg compareConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid -compare-with %s: %w", path, err)
+	}
+	if cfg.Repo != "" && cfg.Account != "" {
+		return nil, fmt.Errorf("invalid -compare-with %s: repo and account cannot be combined", path)
+	}
+	for name, level := range cfg.Permissions {
+		if !permissionLevels[level] {
+			return nil, fmt.Errorf("invalid -compare-with %s: unknown permission level %q for %s", path, level, name)
+		}
+	}
+	if cfg.PrivateKey != "" {
+		if _, err := newKeySource(cfg.PrivateKey, nil); err != nil {
+			return nil, fmt.Errorf("invalid -compare-with %s: %w", path, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// forCompare returns a copy of the Generator configured for the -compare-with document.
+// Permissions given in the document replace -permission, -read-only and -permission-profile altogether so that the document states the whole request.
+func (g *Generator) forCompare(cfg *compareConfig) *Generator {
+	c := *g
+	if cfg.AppID != 0 {
+		c.appID = cfg.AppID
+		c.clientID = ""
+	}
+	if cfg.PrivateKey != "" {
+		// loadCompareConfig has rejected private keys newKeySource cannot read.
+		source, _ := newKeySource(cfg.PrivateKey, c.keyFetchClient)
+		c.privateKeys = keySources{source}
+	}
+	if cfg.Repo != "" {
+		c.installedRepository = cfg.Repo
+		c.account = ""
+	}
+	if cfg.Account != "" {
+		c.account = cfg.Account
+		c.installedRepository = ""
+	}
+	if cfg.Permissions != nil {
+		c.permissions = permissionsFlag(cfg.Permissions)
+		c.profilePermissions = nil
+		c.readOnly = false
+	}
+	if cfg.Repositories != nil {
+		c.scopeRepos = cfg.Repositories
+	}
+	return &c
+}
+
+// tokenScope is what -compare-with compares: everything about a token but the token itself.
+type tokenScope struct {
+	permissions         map[string]string
+	repositorySelection string
+	repositories        []string
+}
+
+func scopeOf(t *installationToken) (*tokenScope, error) {
+	perms, err := permissionsToMap(t.token.GetPermissions())
+	if err != nil {
+		return nil, err
+	}
+	scope := &tokenScope{permissions: perms, repositorySelection: t.repositorySelection}
+	for _, repo := range t.token.Repositories {
+		scope.repositories = append(scope.repositories, repo.GetFullName())
+	}
+	sort.Strings(scope.repositories)
+	return scope, nil
+}
+
+// scopeDifference is a line of the -compare-with output; an empty side means the permission or repository is absent there.
+type scopeDifference struct {
+	Field    string `json:"field"`
+	Current  string `json:"current"`
+	Compared string `json:"compared"`
+}
+
+func diffScopes(current, compared *tokenScope) []scopeDifference {
+	var diffs []scopeDifference
+	names := map[string]string{}
+	for name, level := range current.permissions {
+		names[name] = level
+	}
+	for name, level := range compared.permissions {
+		if _, ok := names[name]; !ok {
+			names[name] = level
+		}
+	}
+	for _, name := range sortedPermissionNames(names) {
+		if current.permissions[name] != compared.permissions[name] {
+			diffs = append(diffs, scopeDifference{Field: "permissions." + name, Current: current.permissions[name], Compared: compared.permissions[name]})
+		}
+	}
+	if current.repositorySelection != compared.repositorySelection {
+		diffs = append(diffs, scopeDifference{Field: "repository_selection", Current: current.repositorySelection, Compared: compared.repositorySelection})
+	}
+	inCompared := map[string]bool{}
+	for _, name := range compared.repositories {
+		inCompared[strings.ToLower(name)] = true
+	}
+	inCurrent := map[string]bool{}
+	for _, name := range current.repositories {
+		inCurrent[strings.ToLower(name)] = true
+		if !inCompared[strings.ToLower(name)] {
+			diffs = append(diffs, scopeDifference{Field: "repositories", Current: name})
+		}
+	}
+	for _, name := range compared.repositories {
+		if !inCurrent[strings.ToLower(name)] {
+			diffs = append(diffs, scopeDifference{Field: "repositories", Compared: name})
+		}
+	}
+	return diffs
+}
+
+// runCompare mints a token under the current flags and another under the -compare-with document, and prints how their scopes differ.
+// The tokens are never printed and are revoked once compared. With -strict a difference is an error; the per-token -strict check is skipped so that the diff is always shown.
+func (g *Generator) runCompare(ctx context.Context) (err error) {
+	cfg, err := loadCompareConfig(g.compareWith)
+	if err != nil {
+		return err
+	}
+	base := *g
+	base.strict = false
+	var scopes []*tokenScope
+	var tokens []*installationToken
+	defer func() {
+		for _, t := range tokens {
+			if revokeErr := g.revokeMinted(t.output()); revokeErr != nil && err == nil {
+				err = revokeErr
+			}
+		}
+	}()
+	for _, c := range []*Generator{&base, base.forCompare(cfg)} {
+		minted, err := c.generateInstallationToken(ctx)
+		if err != nil {
+			return fmt.Errorf("generateInstallationToken(): %w", err)
+		}
+		tokens = append(tokens, minted)
+		scope, err := scopeOf(minted)
+		if err != nil {
+			return err
+		}
+		scopes = append(scopes, scope)
+	}
+	diffs := diffScopes(scopes[0], scopes[1])
+	if g.outputFormat == formatJSON {
+		if diffs == nil {
+			diffs = []scopeDifference{}
+		}
+		s, err := marshalJSON(map[string]interface{}{"differences": diffs})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(g.outStream, s)
+	} else {
+		if len(diffs) == 0 {
+			fmt.Fprintln(g.outStream, "no differences")
+		}
+		for _, d := range diffs {
+			fmt.Fprintf(g.outStream, "%s: %s -> %s\n", d.Field, describeSide(d.Current), describeSide(d.Compared))
+		}
+	}
+	if g.strict && len(diffs) > 0 {
+		return fmt.Errorf("the scopes differ in %d places", len(diffs))
+	}
+	return nil
+}
+
+func describeSide(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
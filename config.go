@@ -0,0 +1,75 @@
+package generatetoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// effectiveConfig is the resolved configuration printed by -print-config.
+// It names where the private key comes from but never includes the key itself.
+type effectiveConfig struct {
+	AppID       int64             `json:"app_id"`
+	ClientID    string            `json:"client_id,omitempty"`
+	PrivateKeys []string          `json:"private_keys"`
+	KeyID       string            `json:"kid,omitempty"`
+	Liveness    string            `json:"liveness"`
+	IssuedAt    *time.Time        `json:"iat,omitempty"`
+	ExpiresAt   *time.Time        `json:"exp,omitempty"`
+	Repository  string            `json:"repo,omitempty"`
+	Account     string            `json:"account,omitempty"`
+	Permissions map[string]string `json:"permissions,omitempty"`
+	ReadOnly    bool              `json:"read_only"`
+	Profile     string            `json:"permission_profile,omitempty"`
+	ScopeRepos  []string          `json:"scope_repos,omitempty"`
+	Strict      bool              `json:"strict"`
+	BaseURL     string            `json:"base_url"`
+	Format      string            `json:"format"`
+	// Sources names where app_id, private_keys, kid, base_url and repo came from: flag, env, config or default.
+	Sources map[string]string `json:"sources"`
+}
+
+func (g *Generator) effectiveConfig() *effectiveConfig {
+	cfg := &effectiveConfig{
+		AppID:       g.appID,
+		ClientID:    g.clientID,
+		PrivateKeys: g.privateKeys.names(),
+		KeyID:       g.keyID,
+		Liveness:    g.tokenLiveness.String(),
+		Repository:  g.installedRepository,
+		Account:     g.account,
+		Permissions: map[string]string{},
+		ReadOnly:    g.readOnly,
+		Profile:     g.permissionProfile,
+		ScopeRepos:  g.scopeRepos,
+		Strict:      g.strict,
+		BaseURL:     g.baseURL,
+		Format:      g.outputFormat,
+		Sources:     g.sources,
+	}
+	for name, level := range g.profilePermissions {
+		cfg.Permissions[name] = level
+	}
+	for name, level := range g.permissions {
+		cfg.Permissions[name] = level
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.github.com/"
+	}
+	if !g.issuedAt.IsZero() {
+		cfg.IssuedAt = &g.issuedAt
+	}
+	if !g.expiresAt.IsZero() {
+		cfg.ExpiresAt = &g.expiresAt
+	}
+	return cfg
+}
+
+func (g *Generator) printConfig() error {
+	b, err := json.MarshalIndent(g.effectiveConfig(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent(): %w", err)
+	}
+	fmt.Fprintln(g.outStream, string(b))
+	return nil
+}
@@ -0,0 +1,54 @@
+package generatetoken
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintConfigExitsBeforeOtherModes(t *testing.T) {
+	cases := []struct {
+		name string
+		args func(t *testing.T, m *mockGitHub) []string
+	}{
+		{
+			name: "-batch-file",
+			args: func(t *testing.T, m *mockGitHub) []string {
+				return m.args("-batch-file", writeBatchFile(t, 2, "acme/api"))
+			},
+		},
+		{
+			name: "-all-installations",
+			args: func(t *testing.T, m *mockGitHub) []string { return m.args("-all-installations") },
+		},
+		{
+			name: "-dump-public-key",
+			args: func(t *testing.T, m *mockGitHub) []string { return m.args("-dump-public-key") },
+		},
+		{
+			name: "-repo",
+			args: func(t *testing.T, m *mockGitHub) []string { return m.args("-repo", "acme/api") },
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			code, stdout, stderr := runCLI(append(tc.args(t, m), "-print-config"))
+			if code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			var cfg effectiveConfig
+			if err := json.Unmarshal([]byte(stdout), &cfg); err != nil {
+				t.Fatalf("stdout = %q, want the configuration: %s", stdout, err)
+			}
+			if strings.Contains(stdout, "ghs_mock") || strings.Contains(stdout, "PUBLIC KEY") {
+				t.Errorf("stdout = %q, want only the configuration", stdout)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.requests != 0 {
+				t.Errorf("%d API requests made, want none", m.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package generatetoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// credentialsDocument is the schema of -credentials-file. Fields are only ever added to it so that consumers keep working:
+//
+//	{
+//	  "token": "<token>",
+//	  "expires_at": "<RFC3339>",
+//	  "installation_id": 42,
+//	  "account_login": "<login>",
+//	  "target_type": "Organization",
+//	  "permissions": {"contents": "read"},
+//	  "repositories": ["<owner>/<name>"]
+//	}
+//
+// expires_at is always RFC3339 regardless of -expiry-format. The installation fields, permissions and repositories are omitted for the app JWT;
+// repositories is omitted when the token covers every repository of the installation.
+type credentialsDocument struct {
+	Token          string            `json:"token"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	InstallationID int64             `json:"installation_id,omitempty"`
+	AccountLogin   string            `json:"account_login,omitempty"`
+	TargetType     string            `json:"target_type,omitempty"`
+	Permissions    map[string]string `json:"permissions,omitempty"`
+	Repositories   []string          `json:"repositories,omitempty"`
+}
+
+func newCredentialsDocument(out *tokenOutput) (*credentialsDocument, error) {
+	doc := &credentialsDocument{
+		Token:          out.Token,
+		ExpiresAt:      out.ExpiresAt,
+		InstallationID: out.InstallationID,
+		AccountLogin:   out.AccountLogin,
+		TargetType:     out.TargetType,
+	}
+	if out.permissions != nil {
+		perms, err := permissionsToMap(out.permissions)
+		if err != nil {
+			return nil, err
+		}
+		doc.Permissions = perms
+	}
+	for _, repo := range out.repositories {
+		doc.Repositories = append(doc.Repositories, repo.GetFullName())
+	}
+	return doc, nil
+}
+
+// writeCredentialsFile writes the credentials document to a temporary file next to path and renames it over path,
+// so that readers never see a partially written token.
+func writeCredentialsFile(path string, out *tokenOutput) error {
+	doc, err := newCredentialsDocument(out)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent(): %w", err)
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	// ioutil.TempFile creates the file with mode 0600.
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("ioutil.TempFile(): %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("write(%s): %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close(%s): %w", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename(%s): %w", path, err)
+	}
+	return nil
+}
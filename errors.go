@@ -0,0 +1,157 @@
+package generatetoken
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+)
+
+var (
+	// ErrMissingPrivateKey is returned when no private key is given.
+	ErrMissingPrivateKey = errors.New("-private-key is required")
+	// ErrMissingAppID is returned when no App ID is given.
+	ErrMissingAppID = errors.New("-id is required")
+)
+
+// exitCodeMaintenance is the exit status when GitHub API answers that it is under maintenance.
+// It follows EX_TEMPFAIL of sysexits(3) so that callers can tell an outage from a configuration or authentication problem.
+const exitCodeMaintenance = 75
+
+type maintenanceError struct {
+	err error
+}
+
+func (e *maintenanceError) Error() string {
+	msg := "GitHub API is in maintenance mode; retry later"
+	if id := requestIDOf(e.err); id != "" {
+		msg += " (" + headerRequestID + ": " + id + ")"
+	}
+	return msg
+}
+
+func (e *maintenanceError) Unwrap() error {
+	return e.err
+}
+
+func (e *maintenanceError) ExitCode() int {
+	return exitCodeMaintenance
+}
+
+type noAccessError struct {
+	repository string
+	statusCode int
+	err        error
+}
+
+func (e *noAccessError) Error() string {
+	return fmt.Sprintf("the minted token cannot access %s (HTTP %d); check -repo, -scope-repo and the permissions requested", e.repository, e.statusCode)
+}
+
+func (e *noAccessError) Unwrap() error {
+	return e.err
+}
+
+// keyMismatchError is returned by -verify-app when the private key does not authenticate as the App given;
+// err is GitHub's 401 response, or nil when the key authenticated as another App.
+type keyMismatchError struct {
+	msg string
+	err error
+}
+
+func (e *keyMismatchError) Error() string {
+	return e.msg
+}
+
+func (e *keyMismatchError) Unwrap() error {
+	return e.err
+}
+
+// validationError presents the problems of a 422 response one per line instead of go-github's one-line dump.
+type validationError struct {
+	resp *github.ErrorResponse
+}
+
+func (e *validationError) Error() string {
+	lines := []string{fmt.Sprintf("%s %s: %d %s", e.resp.Response.Request.Method, e.resp.Response.Request.URL.Path, e.resp.Response.StatusCode, e.resp.Message)}
+	for _, problem := range e.resp.Errors {
+		lines = append(lines, "  "+formatValidationProblem(problem))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *validationError) Unwrap() error {
+	return e.resp
+}
+
+// formatValidationProblem renders a problem as "field: message (code)"; custom errors carry only a message, the others only a code.
+func formatValidationProblem(problem github.Error) string {
+	subject := problem.Field
+	if subject == "" {
+		subject = problem.Resource
+	}
+	detail := problem.Message
+	switch {
+	case detail == "":
+		detail = problem.Code
+	case problem.Code != "" && problem.Code != "custom":
+		detail += " (" + problem.Code + ")"
+	}
+	if subject == "" {
+		return detail
+	}
+	return subject + ": " + detail
+}
+
+// headerRequestID identifies a request in GitHub's logs; quoting it in a support ticket lets GitHub find what happened to the request.
+const headerRequestID = "X-GitHub-Request-Id"
+
+// requestIDError appends the request ID of the failed response to the message of err, on a line of its own when the message spans lines.
+type requestIDError struct {
+	err       error
+	requestID string
+}
+
+func (e *requestIDError) Error() string {
+	msg := e.err.Error()
+	if strings.Contains(msg, "\n") {
+		return msg + "\n  " + headerRequestID + ": " + e.requestID
+	}
+	return msg + " (" + headerRequestID + ": " + e.requestID + ")"
+}
+
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+// requestIDOf returns the request ID of the GitHub API response err carries, or the empty string.
+func requestIDOf(err error) string {
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil {
+		return ""
+	}
+	return errResp.Response.Header.Get(headerRequestID)
+}
+
+// classifyAPIError turns well-known GitHub API failures into dedicated errors and returns any other error as is.
+// Errors of responses that carry a request ID are wrapped so that the ID appears in the message.
+func classifyAPIError(err error) error {
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil {
+		return err
+	}
+	if errResp.Response.StatusCode == http.StatusServiceUnavailable && strings.Contains(strings.ToLower(errResp.Message), "maintenance") {
+		// Run prints maintenanceError on its own, so it carries the request ID itself.
+		return &maintenanceError{err: err}
+	}
+	classified := err
+	if errResp.Response.StatusCode == http.StatusUnprocessableEntity && len(errResp.Errors) > 0 && errResp.Response.Request != nil {
+		classified = &validationError{resp: errResp}
+	}
+	if id := requestIDOf(err); id != "" {
+		return &requestIDError{err: classified, requestID: id}
+	}
+	return classified
+}
@@ -0,0 +1,67 @@
+package generatetoken
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+)
+
+func TestValidationErrorRendering(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		requestID string
+		want      []string
+	}{
+		{
+			name: "one problem per line",
+			body: `{"message": "Validation Failed", "errors": [` +
+				`{"resource": "InstallationToken", "field": "repositories", "code": "invalid"},` +
+				`{"resource": "InstallationToken", "field": "permissions", "code": "custom", "message": "contents is not granted"},` +
+				`{"resource": "InstallationToken", "code": "missing_field"},` +
+				`{"message": "There is at least one repository that does not exist or is not accessible"}]}`,
+			want: []string{
+				"POST /api/v3/app/installations/42/access_tokens: 422 Validation Failed",
+				"  repositories: invalid",
+				"  permissions: contents is not granted",
+				"  InstallationToken: missing_field",
+				"  There is at least one repository that does not exist or is not accessible",
+			},
+		},
+		{
+			name:      "request ID on a line of its own",
+			body:      `{"message": "Validation Failed", "errors": [{"field": "repositories", "message": "is too long", "code": "too_long"}]}`,
+			requestID: "0000:1111:2222",
+			want: []string{
+				"POST /api/v3/app/installations/42/access_tokens: 422 Validation Failed",
+				"  repositories: is too long (too_long)",
+				"  " + headerRequestID + ": 0000:1111:2222",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			m.mint = func(w http.ResponseWriter, r *http.Request, opts *github.InstallationTokenOptions) {
+				if tc.requestID != "" {
+					w.Header().Set(headerRequestID, tc.requestID)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(tc.body))
+			}
+			code, stdout, stderr := runCLI(m.args("-repo", "acme/api", "-scope-repo", "api"))
+			if code != 1 {
+				t.Fatalf("Run() = %d, want 1: %s", code, stderr)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want nothing", stdout)
+			}
+			if want := strings.Join(tc.want, "\n"); !strings.Contains(stderr, want) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package generatetoken
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execTokenEnv is the environment variable the command run after -- receives the token in.
+const execTokenEnv = "GITHUB_TOKEN"
+
+// revokeTimeout bounds revoking a token that has served its purpose. It is not part of -timeout, which the command after -- may well have outlived.
+const revokeTimeout = 30 * time.Second
+
+// commandExitError carries the exit status of the command run after -- so that Run exits with it.
+type commandExitError struct {
+	command string
+	err     *exec.ExitError
+}
+
+func (e *commandExitError) Error() string {
+	return fmt.Sprintf("%s: %s", e.command, e.err)
+}
+
+func (e *commandExitError) Unwrap() error {
+	return e.err
+}
+
+func (e *commandExitError) ExitCode() int {
+	return e.err.ExitCode()
+}
+
+// commandArgs returns the command given after --, or nil when argv has none.
+// Only arguments after an explicit -- are run so that a stray positional argument is never executed.
+func commandArgs(argv []string, rest []string) []string {
+	if len(rest) == 0 {
+		return nil
+	}
+	if i := len(argv) - len(rest) - 1; i < 0 || argv[i] != "--" {
+		return nil
+	}
+	return rest
+}
+
+// execWithToken runs command with the token in GITHUB_TOKEN, then revokes an installation token whatever the command's outcome.
+// Interrupts are forwarded to the command instead of terminating the generator so that revocation still happens.
+func (g *Generator) execWithToken(out *tokenOutput, command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), execTokenEnv+"="+out.Token)
+	cmd.Stdin = g.inStream
+	cmd.Stdout = g.outStream
+	cmd.Stderr = g.errStream
+	if err := cmd.Start(); err != nil {
+		// The failure to start is what matters here, even if revocation fails under -werror too.
+		_ = g.revokeMinted(out)
+		return fmt.Errorf("exec(%s): %w", command[0], err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	for waiting := true; waiting; {
+		select {
+		case sig := <-signals:
+			_ = cmd.Process.Signal(sig)
+		case err = <-done:
+			waiting = false
+		}
+	}
+	revokeErr := g.revokeMinted(out)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &commandExitError{command: command[0], err: exitErr}
+	}
+	if err != nil {
+		return fmt.Errorf("exec(%s): %w", command[0], err)
+	}
+	return revokeErr
+}
+
+// revokeMinted revokes an installation token that has served its purpose, after the command or the -compare-with comparison;
+// a failure is warned about and is an error only under -werror.
+func (g *Generator) revokeMinted(out *tokenOutput) error {
+	if out.InstallationID == 0 {
+		// The app JWT cannot be revoked; it simply expires after -liveness.
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	if err := g.revokeToken(ctx, out.Token); err != nil {
+		return g.warn("failed to revoke the installation token: %s", err)
+	}
+	g.logf("revoked the installation token of installation %d", out.InstallationID)
+	return nil
+}
+
+// runRevoke revokes the token given by -revoke; - reads it from stdin to keep it out of argv.
+func (g *Generator) runRevoke(ctx context.Context) error {
+	token := g.revokeTarget
+	if token == "-" {
+		line, err := bufio.NewReader(g.inStream).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read -revoke from stdin: %w", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		return errors.New("-revoke was given an empty token")
+	}
+	if err := g.revokeToken(ctx, token); err != nil {
+		return err
+	}
+	g.logf("revoked the installation token")
+	return nil
+}
+
+// maskRevokeCommand masks the token -print-revoke-cmd will print under GitHub Actions, as the command line would otherwise expose it in the log.
+// out carries the token before -encode. It runs before the token is printed, and does nothing when -mask has masked this very value already.
+func (g *Generator) maskRevokeCommand(out *tokenOutput) {
+	if out.InstallationID == 0 {
+		return
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" && (!g.mask || g.encoding != "") {
+		fmt.Fprintf(g.errStream, "::add-mask::%s\n", out.Token)
+	}
+}
+
+// printRevokeCommand prints the command revoking the minted installation token to errStream so that it never mixes with the token on stdout.
+// out carries the token before -encode; maskRevokeCommand has masked it already.
+func (g *Generator) printRevokeCommand(name string, out *tokenOutput) {
+	if out.InstallationID == 0 {
+		return
+	}
+	args := []string{shellQuote(name)}
+	if g.baseURL != "" {
+		args = append(args, "-base-url", shellQuote(g.baseURL))
+	}
+	args = append(args, "-revoke", shellQuote(out.Token))
+	fmt.Fprintf(g.errStream, "to revoke the token: %s\n", strings.Join(args, " "))
+}
+
+// revokeToken revokes the installation token so that it stops working before it expires.
+func (g *Generator) revokeToken(ctx context.Context, token string) error {
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Apps.RevokeInstallationToken(ctx); err != nil {
+		return fmt.Errorf("Apps.RevokeInstallationToken(): %w", classifyAPIError(err))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package generatetoken
+
+import (
+	"fmt"
+	"time"
+)
+
+// Exit statuses of -check-expiry besides 0, which means the token stays valid beyond -refresh-margin.
+const (
+	// exitCodeMalformedExpiry means -check-expiry is not an RFC3339 timestamp, so that a typo is never taken for a fresh token.
+	exitCodeMalformedExpiry = 2
+	// exitCodeNeedsRefresh means the token has expired or expires within -refresh-margin.
+	// It is also the exit status when the token printed would not last -require-remaining.
+	exitCodeNeedsRefresh = 3
+)
+
+// expiryCheckError is returned by -check-expiry for anything but a fresh token.
+type expiryCheckError struct {
+	msg  string
+	code int
+}
+
+func (e *expiryCheckError) Error() string {
+	return e.msg
+}
+
+func (e *expiryCheckError) ExitCode() int {
+	return e.code
+}
+
+// checkExpiry tells whether a token expiring at -check-expiry needs to be minted again; it calls nothing, GitHub included.
+func (g *Generator) checkExpiry(now time.Time) error {
+	expiresAt, err := time.Parse(time.RFC3339, g.checkExpiryAt)
+	if err != nil {
+		return &expiryCheckError{msg: fmt.Sprintf("malformed -check-expiry: %s", err), code: exitCodeMalformedExpiry}
+	}
+	if remaining := expiresAt.Sub(now); remaining <= g.refreshMargin {
+		return &expiryCheckError{msg: fmt.Sprintf("token expires at %s, within -refresh-margin %s; refresh it", expiresAt.Format(time.RFC3339), g.refreshMargin), code: exitCodeNeedsRefresh}
+	}
+	g.logf("token is fresh until %s", expiresAt.Add(-g.refreshMargin).Format(time.RFC3339))
+	return nil
+}
+
+// checkRemaining fails when the token expires sooner than -require-remaining from now.
+func (g *Generator) checkRemaining(out *tokenOutput, now time.Time) error {
+	if out.ExpiresAt == nil {
+		return &expiryCheckError{msg: "-require-remaining is set but the token expiry is unknown", code: exitCodeNeedsRefresh}
+	}
+	if remaining := out.ExpiresAt.Sub(now); remaining < g.requireRemaining {
+		return &expiryCheckError{msg: fmt.Sprintf("token expires at %s, leaving %s of the %s -require-remaining", out.ExpiresAt.Format(time.RFC3339), remaining.Truncate(time.Second), g.requireRemaining), code: exitCodeNeedsRefresh}
+	}
+	return nil
+}
@@ -1,125 +1,938 @@
 package generatetoken
 
 import (
+	"bufio"
 	"context"
-	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v45/github"
 	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"golang.org/x/oauth2"
 )
 
-func NewGenerator(outStream, errStream io.Writer) *Generator {
-	return &Generator{outStream: outStream, errStream: errStream}
+// NewGenerator returns a Generator configured by opts. The defaults match those of the command line flags.
+func NewGenerator(outStream, errStream io.Writer, opts ...Option) *Generator {
+	g := &Generator{
+		inStream:      os.Stdin,
+		outStream:     outStream,
+		errStream:     errStream,
+		parsedKeys:    &keyCache{},
+		installations: &installationCache{},
+		retries:       &retryBudget{},
+		appIDFD:       -1,
+		tokenLiveness: time.Minute,
+		minKeyBits:    defaultMinKeyBits,
+		concurrency:   defaultConcurrency,
+		colorMode:     colorAuto,
+		outputFormat:  formatText,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
 }
 
 type Generator struct {
+	inStream  io.Reader
 	outStream io.Writer
 	errStream io.Writer
 
-	privateKeyPath      string
-	appID               int64
-	tokenLiveness       time.Duration
-	installedRepository string
+	privateKeys          keySources
+	verbose              bool
+	logFilePath          string
+	logStream            io.Writer
+	colorMode            string
+	noNewline            bool
+	outputFormat         string
+	vaultFieldName       string
+	envName              string
+	expiryFormat         string
+	describe             bool
+	mask                 bool
+	keychainService      string
+	keychainAccount      string
+	verifyApp            bool
+	verifyJWT            bool
+	requireInstallation  bool
+	keyID                string
+	includeKID           bool
+	appID                int64
+	appIDFD              int
+	clientID             string
+	tokenLiveness        time.Duration
+	issuedAt             time.Time
+	expiresAt            time.Time
+	installedRepository  string
+	account              string
+	appSlug              string
+	baseURL              string
+	printConfigOnly      bool
+	permissions          permissionsFlag
+	readOnly             bool
+	permissionProfile    string
+	profilesFile         string
+	profilePermissions   map[string]string
+	scopeRepos           stringsFlag
+	maxRepos             int
+	tokenOptionsJSON     string
+	rawTokenOptions      *github.InstallationTokenOptions
+	strict               bool
+	preflightPermissions bool
+	attempts             int
+	retryBudget          int
+	timeout              time.Duration
+	backoffStrategy      string
+	retryDelay           time.Duration
+	maxRetryDelay        time.Duration
+	backoff              backoff
+	batchFile            string
+	failFast             bool
+	showPermissionsOnly  bool
+	credentialsFile      string
+	accept               string
+	verifyAccess         string
+	configPath           string
+	sources              map[string]string
+	encoding             string
+	outFile              string
+	tee                  bool
+	validateConfigOnly   bool
+	allInstallations     bool
+	minKeyBits           int
+	strictKey            bool
+	notifyURL            string
+	refreshUserTokenOnly bool
+	revokeTarget         string
+	printRevokeCmd       bool
+	repoFromEnv          bool
+	summary              bool
+	ipVersion            string
+	showSingleFileOnly   bool
+	checkExpiryAt        string
+	refreshMargin        time.Duration
+	stdinFormat          string
+	noNetwork            bool
+	outFileTemplate      string
+	stateFile            string
+	extraHeaders         http.Header
+	gitHubOutput         bool
+	jwtClaims            claimsFlag
+	jwtTyp               string
+	compareWith          string
+	logger               Logger
+	tokenSink            TokenSink
+	minimalClaims        bool
+	probeRateLimitOnly   bool
+	minRateLimit         int
+	allowedRepoValues    stringsFlag
+	allowedRepos         repoAllowList
+	requireRemaining     time.Duration
+	concurrency          int
+	reportOnExitLine     bool
+	dumpPublicKeyOnly    bool
+	statsdAddr           string
+	fromGHConfig         bool
+	allowSuspended       bool
+	werror               bool
+	rawResponse          string
+
+	parsedKeys    *keyCache
+	installations *installationCache
+	retries       *retryBudget
+	observer      Observer
 }
 
 func (g *Generator) Run(argv []string) int {
-	var exitCode int
-	if err := g.run(argv); err != nil {
-		fmt.Fprintln(g.errStream, err)
-		if a, ok := err.(interface{ ExitCode() int }); ok {
-			exitCode = a.ExitCode()
+	start := time.Now()
+	err := g.run(argv)
+	if g.statsdAddr != "" {
+		g.sendStatsD(err, time.Since(start))
+	}
+	if err != nil {
+		var maintenance *maintenanceError
+		if errors.As(err, &maintenance) {
+			err = maintenance
+		}
+		fmt.Fprintln(g.errStream, g.colorize(g.errStream, severityError, err.Error()))
+		var a interface{ ExitCode() int }
+		if errors.As(err, &a) {
+			return a.ExitCode()
 		}
+		return 1
 	}
-	return exitCode
+	return 0
 }
 
 func (g *Generator) shouldGenerateInstallationToken() bool {
-	return g.installedRepository != ""
+	return g.installedRepository != "" || g.account != ""
 }
 
 func (g *Generator) run(argv []string) error {
 	fset := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+	fset.Usage = usage(fset)
 	fset.Int64Var(&g.appID, "id", 0, "GitHub App ID")
-	fset.StringVar(&g.privateKeyPath, "private-key", "", "GitHub App private key")
-	fset.DurationVar(&g.tokenLiveness, "liveness", time.Minute, "token liveness")
-	fset.StringVar(&g.installedRepository, "repo", "", "installed repository qualified name; indicates the generator to generate repository installation token")
+	fset.Var(privateKeySourcesFlag{sources: &g.privateKeys, client: g.keyFetchClient}, "private-key", "GitHub App private key as a path, file:// URL or https:// URL to fetch it from; may be repeated to try each key in order during key rotation")
+	fset.Var(fdKeySourcesFlag{&g.privateKeys}, "private-key-fd", "file descriptor number to read the GitHub App private key from; may be repeated like -private-key")
+	fset.StringVar(&g.clientID, "client-id", "", "GitHub App client ID (Iv1.../Iv23...) to use as the JWT issuer instead of -id")
+	fset.IntVar(&g.appIDFD, "id-fd", -1, "file descriptor number to read the GitHub App ID from")
+	fset.IntVar(&g.minKeyBits, "min-key-bits", defaultMinKeyBits, "warn when the RSA private key is shorter than this many bits; 2048 or more is recommended")
+	fset.BoolVar(&g.strictKey, "strict-key", false, "fail instead of warning when the private key is shorter than -min-key-bits")
+	fset.StringVar(&g.keyID, "kid", "", "key ID to select when the private key is a JWK Set")
+	fset.BoolVar(&g.includeKID, "include-kid", false, "set the key's kid in the JWT header when the key carries one")
+	fset.Var(livenessFlag{&g.tokenLiveness}, "liveness", "token liveness as a `duration` from now, or max for 9m: GitHub's upper limit of 10m less the 1m iat is backdated by for clock drift")
+	g.jwtClaims = claimsFlag{}
+	fset.Var(g.jwtClaims, "jwt-claim", "extra app JWT claim as name=value for testing against mock servers; may be repeated; may break authentication with GitHub")
+	fset.StringVar(&g.jwtTyp, "jwt-typ", "", "typ header of the app JWT instead of JWT, for testing against mock servers; may break authentication with GitHub")
+	fset.BoolVar(&g.minimalClaims, "minimal-claims", false, "fail instead of signing an app JWT with claims other than iss, iat and exp")
+	fset.Func("iat", "explicit JWT iat claim in RFC3339; overrides the computed value, and exp defaults to 9m after it (advanced)", timeFlag(&g.issuedAt))
+	fset.Func("exp", "explicit JWT exp claim in RFC3339; overrides the computed value (advanced)", timeFlag(&g.expiresAt))
+	fset.StringVar(&g.installedRepository, "repo", "", "installed repository qualified name; indicates the generator to generate repository installation token; - reads it from stdin")
+	g.permissions = permissionsFlag{}
+	fset.Var(g.permissions, "permission", "permission to request for the installation token as name=level (e.g. contents=read); may be repeated and overrides presets")
+	fset.BoolVar(&g.readOnly, "read-only", false, "request read access for every permission the installation has")
+	fset.StringVar(&g.permissionProfile, "permission-profile", "", "named set of permissions to request; built-in profiles are ci-read, ci-deploy and pr-bot")
+	fset.StringVar(&g.profilesFile, "profiles-file", "", "JSON file defining custom permission profiles as {\"name\":{\"permission\":\"level\"}}")
+	fset.Var(&g.allowedRepoValues, "allowed-repos", "comma-separated owner/name or owner/* the token may be minted for, matched case-insensitively; may be repeated; defaults to GITHUB_APP_ALLOWED_REPOS")
+	fset.Var(&g.scopeRepos, "scope-repo", "repository name (without owner) to restrict the installation token to; may be repeated")
+	fset.IntVar(&g.maxRepos, "max-repos", 0, "fail when the token would be scoped to more than this many repositories, by -scope-repo or -token-options-json; 0 means unlimited")
+	fset.StringVar(&g.tokenOptionsJSON, "token-options-json", "", "JSON object passed to the create-installation-token request as is; values from flags such as -scope-repo and -permission win")
+	fset.BoolVar(&g.allowSuspended, "allow-suspended", false, "try to mint a token even when the installation is suspended, warning instead of failing")
+	fset.BoolVar(&g.preflightPermissions, "preflight-permissions", false, "fail before minting when the installation lacks any requested permission")
+	fset.BoolVar(&g.strict, "strict", false, "fail when the minted token's repositories or permissions differ from the requested ones")
+	fset.BoolVar(&g.refreshUserTokenOnly, "refresh-user-token", false, "refresh a user-to-server token instead of minting an installation token: reads the refresh token from stdin and needs -client-id and GITHUB_APP_CLIENT_SECRET")
+	fset.StringVar(&g.revokeTarget, "revoke", "", "revoke the given installation token and exit; - reads it from stdin")
+	fset.StringVar(&g.rawResponse, "raw-response", "", "append the create-installation-token response body, pretty-printed with the token masked, to the file (mode 0600); - writes it to stderr")
+	fset.BoolVar(&g.printRevokeCmd, "print-revoke-cmd", false, "print the command revoking the minted installation token to stderr")
+	fset.BoolVar(&g.showPermissionsOnly, "show-permissions", false, "print the permissions the installation grants the App and exit; requires -repo or -account")
+	fset.StringVar(&g.stdinFormat, "stdin-format", "", "read the configuration, private key included, from stdin; json is the only format")
+	fset.BoolVar(&g.fromGHConfig, "from-gh-config", false, "take the GitHub Enterprise Server host gh is logged in to from its hosts.yml when no base URL is given otherwise; GH_HOST chooses among several")
+	fset.StringVar(&g.configPath, "config", "", "JSON file providing app_id, private_key, kid and base_url; flags and then GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY_FILE, GITHUB_APP_KID and GITHUB_APP_BASE_URL take precedence over it")
+	fset.BoolVar(&g.validateConfigOnly, "validate-config", false, "check the flags, environment and -config together with the private keys without calling GitHub, report every problem and exit")
+	fset.StringVar(&g.checkExpiryAt, "check-expiry", "", "RFC3339 expiry of a token minted before; exit 0 when it is fresh, 3 when it needs refreshing and 2 when malformed, without calling GitHub")
+	fset.DurationVar(&g.requireRemaining, "require-remaining", 0, "fail unless the installation token stays valid at least this long, whether minted or reused by -since-last-run")
+	fset.DurationVar(&g.refreshMargin, "refresh-margin", 5*time.Minute, "treat a token as needing refresh this long before -check-expiry")
+	fset.BoolVar(&g.showSingleFileOnly, "show-single-file", false, "print the installation's single_file permission and the files it covers and exit; requires -repo or -account")
+	fset.BoolVar(&g.dumpPublicKeyOnly, "dump-public-key", false, "print the public key of the private key as PEM, or as a JWK with -format jwk, and exit; needs neither -id nor the network")
+	fset.BoolVar(&g.probeRateLimitOnly, "probe-rate-limit", false, "print the core, search and graphql rate limits of the app JWT and exit")
+	fset.IntVar(&g.minRateLimit, "min-rate-limit", 0, "with -probe-rate-limit, fail when fewer core requests than this remain")
+	fset.StringVar(&g.compareWith, "compare-with", "", "JSON file with app_id, private_key, repo, account, permissions and repositories overriding the flags; mints a token under each, prints how their scopes differ without printing the tokens and revokes both")
+	fset.BoolVar(&g.printConfigOnly, "print-config", false, "print the resolved configuration as JSON without secrets and exit")
+	fset.BoolVar(&g.repoFromEnv, "repo-from-env", false, "take -repo from GITHUB_REPOSITORY, as set by GitHub Actions, when neither -repo nor -account is given")
+	fset.StringVar(&g.account, "account", "", "organization or user login the App is installed on; generates the installation token for that account")
+	fset.StringVar(&g.appSlug, "app-slug", "", "App slug to look up the App ID by when -id is not given; with -id or -id-fd, fail unless the slug belongs to that App")
+	fset.StringVar(&g.colorMode, "color", colorAuto, "color diagnostic messages; one of: auto, always, never (auto honors NO_COLOR)")
+	fset.BoolVar(&g.verbose, "verbose", false, "print diagnostic messages to stderr")
+	fset.BoolVar(&g.werror, "werror", false, "fail on anything that would only be warned about, such as a short private key or a failed -notify-url")
+	fset.StringVar(&g.statsdAddr, "statsd-addr", "", "host:port of a StatsD server to send the run's success or failure counter and duration to over UDP at exit; best effort")
+	fset.StringVar(&g.logFilePath, "log-file", "", "write diagnostic messages to the file instead of stderr; implies -verbose")
+	fset.StringVar(&g.outFile, "out-file", "", "write the output to the file (mode 0600) instead of stdout")
+	fset.BoolVar(&g.tee, "tee", false, "print the output to stdout as well as writing it to -out-file")
+	fset.StringVar(&g.stateFile, "since-last-run", "", "state file (mode 0600) remembering the last installation token; reuse it while it stays valid beyond -refresh-margin for the same request")
+	fset.StringVar(&g.credentialsFile, "credentials-file", "", "also write the token with its expiry, installation, permissions and repositories as JSON to the file (mode 0600)")
+	fset.BoolVar(&g.noNewline, "no-newline", false, "do not print a trailing newline after the token")
+	fset.StringVar(&g.outputFormat, "format", formatText, "output format; one of: text, json, git-credentials, git-config, jwt-debug, vault, shell, jsonl (-batch-file and -all-installations only), jwk (-dump-public-key only)")
+	fset.StringVar(&g.encoding, "encode", "", "encode the token before printing or writing it; base64 is the only encoding")
+	fset.StringVar(&g.envName, "env-name", "APP_TOKEN", "variable name exported by -format shell")
+	fset.StringVar(&g.expiryFormat, "expiry-format", expiryFormatRFC3339, "how expires_at is printed; one of: rfc3339, unix, relative")
+	fset.StringVar(&g.vaultFieldName, "field-name", "token", "name of the token field in -format vault")
+	fset.StringVar(&g.baseURL, "base-url", "", "GitHub Enterprise Server API base URL (e.g. https://ghe.example.com/api/v3/); defaults to github.com")
+	fset.StringVar(&g.ipVersion, "ip-version", ipVersionAuto, "IP version to connect to GitHub over; one of: auto, 4, 6")
+	g.extraHeaders = http.Header{}
+	fset.Var(headersFlag(g.extraHeaders), "header", "extra header as \"Name: Value\" sent with every GitHub API request, e.g. for a gateway; may be repeated; Authorization cannot be set")
+	fset.StringVar(&g.accept, "accept", "", "Accept header sent with every GitHub API request instead of go-github's, e.g. to opt into preview media types")
+	fset.StringVar(&g.keychainService, "keychain-service", "", "store the token in the OS secret store under the service name instead of printing it (requires -tags keychain)")
+	fset.StringVar(&g.keychainAccount, "keychain-account", "", "account name to store the token under with -keychain-service")
+	fset.BoolVar(&g.gitHubOutput, "github-output", false, "also set the token, its expiry, installation and permission_<name> outputs of the GitHub Actions step")
+	fset.BoolVar(&g.mask, "mask", false, "print a GitHub Actions ::add-mask:: command for the token before printing it")
+	fset.BoolVar(&g.reportOnExitLine, "report-on-exit", false, "on success, finish with a line on stderr telling what was minted and where the token went, without the token")
+	fset.BoolVar(&g.summary, "summary", false, "print a one-line summary of what was minted, without the token, to stderr")
+	fset.BoolVar(&g.describe, "describe", false, "print the token's length, prefix and expiry with its body masked instead of the token itself")
+	fset.BoolVar(&g.noNetwork, "no-network", false, "only sign the app JWT and fail if any option would call GitHub or another host")
+	fset.BoolVar(&g.requireInstallation, "require-installation-token", false, "fail instead of printing the app JWT when no installation target such as -repo is given")
+	fset.BoolVar(&g.verifyJWT, "verify-jwt", false, "verify the signed JWT locally with the public key before using it")
+	fset.IntVar(&g.attempts, "attempts", 1, "try the GitHub calls before the token is created up to this many times while they fail with a network error or a 5xx, backing off between attempts; creating the token is only retried when the connection could not be made")
+	fset.IntVar(&g.retryBudget, "retry-budget", 0, "retry at most this many times over the whole run, summed over every call, -batch-file entry and installation; 0 means only -attempts bounds the retries")
+	fset.StringVar(&g.backoffStrategy, "backoff", backoffExponential, "wait strategy between -attempts; one of: constant, exponential, jitter")
+	fset.DurationVar(&g.retryDelay, "retry-delay", time.Second, "base wait between -attempts")
+	fset.DurationVar(&g.maxRetryDelay, "max-retry-delay", 30*time.Second, "upper bound of the wait between -attempts for exponential and jitter backoff")
+	fset.DurationVar(&g.timeout, "timeout", 0, "give up generation after this duration including all attempts; 0 means no timeout")
+	fset.StringVar(&g.batchFile, "batch-file", "", "JSON file listing {app_id, private_key, repo, permissions} entries to mint installation tokens for concurrently")
+	fset.BoolVar(&g.allInstallations, "all-installations", false, "mint an installation token for every installation of the App and print them as a JSON array")
+	fset.StringVar(&g.outFileTemplate, "out-file-template", "", "with -all-installations, write each token to the path rendered from this Go template with {{.InstallationID}} and {{.AccountLogin}} (mode 0600) instead of printing it")
+	fset.IntVar(&g.concurrency, "concurrency", defaultConcurrency, "how many tokens -batch-file and -all-installations mint at the same time")
+	fset.BoolVar(&g.failFast, "fail-fast", false, "stop the batch on the first failing entry")
+	fset.StringVar(&g.notifyURL, "notify-url", "", "POST a JSON summary of each minted installation token, without the token itself, to the URL; failures only warn")
+	fset.StringVar(&g.verifyAccess, "verify-access", "", "owner/repo to fetch with the minted installation token; fails when the token cannot access it")
+	fset.BoolVar(&g.verifyApp, "verify-app", false, "verify the private key belongs to the App given by -id before using it")
 	if err := fset.Parse(argv[1:]); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
-	if g.privateKeyPath == "" {
-		return errors.New("-private-key is required")
+	command := commandArgs(argv, fset.Args())
+	passed := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	if g.validateConfigOnly {
+		return g.validateConfig(passed, command)
 	}
-	if g.appID == 0 {
-		return errors.New("-id is required")
+	if g.checkExpiryAt != "" {
+		return g.checkExpiry(time.Now())
 	}
-	appToken, err := g.generateAppToken()
-	if err != nil {
-		return fmt.Errorf("generateAuthToken(): %w", err)
+	if g.stdinFormat != "" {
+		if err := g.readStdinConfig(passed); err != nil {
+			return err
+		}
+	}
+	if err := g.resolveSources(passed); err != nil {
+		return err
+	}
+	if err := g.resolveAllowedRepos(passed); err != nil {
+		return err
+	}
+	if problems := g.checkConfig(passed, command); len(problems) > 0 {
+		return problems[0]
+	}
+	// checkConfig has rejected unknown strategies.
+	g.backoff, _ = newBackoff(g.backoffStrategy, g.retryDelay, g.maxRetryDelay)
+	if g.appIDFD >= 0 {
+		id, err := readAppIDFromFD(g.appIDFD)
+		if err != nil {
+			return err
+		}
+		g.appID = id
+	}
+	// -print-config exits before anything reaches the network or mints a token, whichever other mode is given with it.
+	if g.printConfigOnly {
+		return g.printConfig()
+	}
+	if g.logFilePath != "" {
+		f, err := os.OpenFile(g.logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("os.OpenFile(%s): %w", g.logFilePath, err)
+		}
+		defer f.Close()
+		g.logStream = f
+		g.verbose = true
+	}
+	ctx := context.Background()
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+	if g.batchFile != "" {
+		return g.runBatch(ctx)
+	}
+	if g.revokeTarget != "" {
+		return g.runRevoke(ctx)
+	}
+	if g.refreshUserTokenOnly {
+		return g.refreshUserToken(ctx)
+	}
+	if g.dumpPublicKeyOnly {
+		return g.dumpPublicKey()
+	}
+	if g.appSlug != "" {
+		id, err := g.resolveAppSlug(ctx)
+		if err != nil {
+			return err
+		}
+		g.appID = id
+	}
+	if g.allInstallations {
+		return g.runAllInstallations(ctx)
+	}
+	if g.installedRepository == "-" {
+		line, err := bufio.NewReader(g.inStream).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read -repo from stdin: %w", err)
+		}
+		g.installedRepository = strings.TrimSpace(line)
+		if owner, repo, found := strings.Cut(g.installedRepository, "/"); !found || owner == "" || repo == "" {
+			return fmt.Errorf("malformed repository name read from stdin: %q", g.installedRepository)
+		}
+	}
+	if g.showPermissionsOnly {
+		return g.showPermissions(ctx)
+	}
+	if g.probeRateLimitOnly {
+		return g.probeRateLimit(ctx)
+	}
+	if g.showSingleFileOnly {
+		return g.showSingleFile(ctx)
+	}
+	if g.compareWith != "" {
+		return g.runCompare(ctx)
+	}
+	var (
+		out *tokenOutput
+		err error
+	)
+	if g.stateFile != "" {
+		if out, err = g.loadState(time.Now()); err != nil {
+			return err
+		}
+		if out != nil {
+			if err := g.checkReusedToken(ctx); err != nil {
+				return err
+			}
+		}
 	}
+	if out == nil {
+		if out, err = g.generate(ctx); err != nil {
+			return err
+		}
+		if g.stateFile != "" {
+			if err := g.saveState(out); err != nil {
+				return err
+			}
+		}
+	}
+	if g.requireRemaining > 0 {
+		if err := g.checkRemaining(out, time.Now()); err != nil {
+			return err
+		}
+	}
+	if command != nil {
+		if err := g.execWithToken(out, command); err != nil {
+			return err
+		}
+		return g.reportOnExit(out, true)
+	}
+	// -revoke takes the token as GitHub issued it, not as -encode prints it.
+	raw := *out
+	if g.printRevokeCmd {
+		g.maskRevokeCommand(&raw)
+	}
+	g.encodeToken(out)
+	if g.credentialsFile != "" {
+		if err := writeCredentialsFile(g.credentialsFile, out); err != nil {
+			return err
+		}
+	}
+	if err := g.printOutput(out); err != nil {
+		return err
+	}
+	if g.gitHubOutput {
+		if err := g.writeGitHubOutput(out); err != nil {
+			return err
+		}
+	}
+	if g.printRevokeCmd {
+		g.printRevokeCommand(fset.Name(), &raw)
+	}
+	if g.summary {
+		line, err := g.summarize(out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(g.errStream, line)
+	}
+	return g.reportOnExit(out, false)
+}
+
+// clientIDPattern matches GitHub App client IDs such as Iv1.0123456789abcdef and Iv23li0123456789abcd.
+var clientIDPattern = regexp.MustCompile(`^Iv[0-9A-Za-z.]+$`)
+
+// generate runs the whole flow from loading the key to minting the token once.
+func (g *Generator) generate(ctx context.Context) (*tokenOutput, error) {
 	if g.shouldGenerateInstallationToken() {
-		installationToken, err := g.generateInstallationToken(context.Background(), string(appToken))
+		minted, err := g.generateInstallationToken(ctx)
 		if err != nil {
-			return fmt.Errorf("generateInstallationToken(): %w", err)
+			return nil, fmt.Errorf("generateInstallationToken(): %w", err)
+		}
+		if g.verifyAccess != "" {
+			if err := g.verifyRepositoryAccess(ctx, minted.token.GetToken()); err != nil {
+				return nil, err
+			}
+		}
+		return minted.output(), nil
+	}
+	var appToken []byte
+	err := g.withAttempts(ctx, isTransient, func(ctx context.Context) error {
+		var err error
+		if appToken, err = g.generateAppToken(ctx, g.privateKeys[0]); err != nil {
+			return fmt.Errorf("generateAuthToken(): %w", err)
+		}
+		if g.verifyApp {
+			return g.verifyAppID(ctx, string(appToken))
 		}
-		fmt.Fprintln(g.outStream, installationToken)
-	} else {
-		fmt.Fprintln(g.outStream, string(appToken))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &tokenOutput{Token: string(appToken)}, nil
+}
+
+func (g *Generator) logf(format string, args ...interface{}) {
+	if g.logger != nil {
+		g.logger.Infof(format, args...)
+		return
+	}
+	if !g.verbose {
+		return
+	}
+	w := g.logStream
+	if w == nil {
+		w = g.errStream
+	}
+	fmt.Fprintln(w, g.colorize(w, severityInfo, fmt.Sprintf(format, args...)))
+}
+
+// warn prints a warning whether or not -verbose is set, or under -werror returns it as an error instead.
+// Every warning goes through here so that -werror catches all of them; callers must return the error when it is not nil.
+func (g *Generator) warn(format string, args ...interface{}) error {
+	if g.werror {
+		return fmt.Errorf("-werror: %s", fmt.Sprintf(format, args...))
+	}
+	if g.logger != nil {
+		g.logger.Warnf(format, args...)
+		return nil
+	}
+	w := g.logStream
+	if w == nil {
+		w = g.errStream
+	}
+	fmt.Fprintln(w, g.colorize(w, severityWarning, "warning: "+fmt.Sprintf(format, args...)))
 	return nil
 }
 
-func (g *Generator) generateInstallationToken(ctx context.Context, appToken string) (string, error) {
-	client := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: appToken})))
-	owner, repo, found := strings.Cut(g.installedRepository, "/")
-	if !found {
-		return "", fmt.Errorf("malformed repository name: %s", g.installedRepository)
+// generateInstallationToken tries each private key in order and returns the first installation token GitHub issues.
+// Only authentication failures (401) and, under -verify-app, keys of another App fall through to the next key;
+// any other error is returned immediately.
+func (g *Generator) generateInstallationToken(ctx context.Context) (*installationToken, error) {
+	for i, keySource := range g.privateKeys {
+		minted, err := g.requestInstallationToken(ctx, keySource)
+		if err == nil {
+			g.logf("authenticated with private key %s", keySource)
+			return minted, nil
+		}
+		var mismatch *keyMismatchError
+		if !isUnauthorized(err) && !errors.As(err, &mismatch) || i == len(g.privateKeys)-1 {
+			return nil, err
+		}
+		g.logf("private key %s was rejected: %s; trying next key", keySource, err)
 	}
-	installation, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	return nil, errors.New("no private key given")
+}
+
+// verifyAppID asks GitHub which App the JWT authenticates as and compares it to -id.
+func (g *Generator) verifyAppID(ctx context.Context, appToken string) error {
+	client, err := g.newClient(ctx, appToken)
 	if err != nil {
-		return "", fmt.Errorf("Apps.FindRepositoryInstallation(): %w", err)
+		return err
+	}
+	app, _, err := client.Apps.Get(ctx, "")
+	if isUnauthorized(err) {
+		return &keyMismatchError{msg: fmt.Sprintf("private key does not match App %s", g.issuer()), err: err}
 	}
-	out, _, err := client.Apps.CreateInstallationToken(ctx, installation.GetID(), &github.InstallationTokenOptions{})
 	if err != nil {
-		return "", fmt.Errorf("Apps.CreateInstallationToken(): %w", err)
+		return fmt.Errorf("Apps.Get(): %w", classifyAPIError(err))
+	}
+	// go-github's App does not carry the client ID, so with -client-id a successful authentication is all that can be checked.
+	if g.clientID == "" && app.GetID() != g.appID {
+		return &keyMismatchError{msg: fmt.Sprintf("private key does not match App id %d (key belongs to App %d)", g.appID, app.GetID())}
 	}
-	return out.GetToken(), nil
+	return nil
+}
+
+func isUnauthorized(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusUnauthorized
 }
 
-func (g *Generator) generateAppToken() ([]byte, error) {
-	rawKey, err := ioutil.ReadFile(g.privateKeyPath)
+// installationToken is a minted installation token along with the installation it was issued for.
+type installationToken struct {
+	token               *github.InstallationToken
+	installation        *github.Installation
+	repositorySelection string
+}
+
+func (t *installationToken) output() *tokenOutput {
+	return &tokenOutput{
+		Token:               t.token.GetToken(),
+		ExpiresAt:           t.token.ExpiresAt,
+		InstallationID:      t.installation.GetID(),
+		AccountLogin:        t.installation.GetAccount().GetLogin(),
+		TargetType:          t.installation.GetTargetType(),
+		permissions:         t.token.GetPermissions(),
+		repositories:        t.token.Repositories,
+		repositorySelection: t.repositorySelection,
+	}
+}
+
+func (g *Generator) newClient(ctx context.Context, token string) (*github.Client, error) {
+	httpClient := g.dialingClient()
+	if token != "" {
+		if httpClient != nil {
+			// oauth2 sends the requests through the client found in the context.
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		}
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	httpClient = g.wrapTransport(httpClient)
+	if g.baseURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	client, err := github.NewEnterpriseClient(g.baseURL, g.baseURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("github.NewEnterpriseClient(): %w", err)
+	}
+	return client, nil
+}
+
+// gitHost returns the host git talks to: github.com or the -base-url host.
+func (g *Generator) gitHost() string {
+	if g.baseURL == "" {
+		return "github.com"
+	}
+	u, err := url.Parse(g.baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", g.privateKeyPath, err)
+		return "github.com"
 	}
-	combinedKey, err := jwk.ParseKey(rawKey, jwk.WithPEM(true))
+	return u.Host
+}
+
+// installationTokenOptions merges -token-options-json with the options derived from flags; flags win on conflict.
+func (g *Generator) installationTokenOptions(installation *github.Installation) (*github.InstallationTokenOptions, error) {
+	opts := g.scopedTokenOptions()
+	perms, err := g.requestedPermissions(installation)
 	if err != nil {
-		return nil, fmt.Errorf("jwk.ParseKey(): %w", err)
+		return nil, err
+	}
+	opts.Permissions = perms
+	return opts, nil
+}
+
+// scopedTokenOptions merges -scope-repo over -token-options-json; it is what installationTokenOptions builds on and -max-repos counts.
+func (g *Generator) scopedTokenOptions() *github.InstallationTokenOptions {
+	opts := &github.InstallationTokenOptions{}
+	if g.rawTokenOptions != nil {
+		*opts = *g.rawTokenOptions
 	}
-	var key rsa.PrivateKey
-	if err := combinedKey.Raw(&key); err != nil {
-		return nil, fmt.Errorf("jwk.Key.Raw(): %w", err)
+	if len(g.scopeRepos) > 0 {
+		opts.Repositories = g.scopeRepos
 	}
+	return opts
+}
+
+// parseTokenOptionsJSON decodes -token-options-json and rejects fields InstallationTokenOptions does not know.
+func parseTokenOptionsJSON(s string) (*github.InstallationTokenOptions, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	var opts github.InstallationTokenOptions
+	if err := dec.Decode(&opts); err != nil {
+		return nil, fmt.Errorf("invalid -token-options-json: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("invalid -token-options-json: trailing data after the JSON object")
+	}
+	return &opts, nil
+}
+
+// requestInstallationToken authenticates as the App with the key, looks up the installation and mints a token for it.
+// The calls before the mint are retried under -attempts, each attempt with a freshly signed app JWT; the mint is not.
+func (g *Generator) requestInstallationToken(ctx context.Context, keySource keySource) (*installationToken, error) {
+	var (
+		client       *github.Client
+		installation *github.Installation
+	)
+	err := g.withAttempts(ctx, isTransient, func(ctx context.Context) error {
+		appToken, err := g.generateAppToken(ctx, keySource)
+		if err != nil {
+			return fmt.Errorf("generateAuthToken(): %w", err)
+		}
+		if g.verifyApp {
+			if err := g.verifyAppID(ctx, string(appToken)); err != nil {
+				return err
+			}
+		}
+		if client, err = g.newClient(ctx, string(appToken)); err != nil {
+			return err
+		}
+		start := time.Now()
+		var resp *github.Response
+		installation, resp, err = g.findInstallation(ctx, client)
+		g.events().InstallationResolved(ctx, InstallationResolvedEvent{
+			InstallationID: installation.GetID(),
+			AccountLogin:   installation.GetAccount().GetLogin(),
+			StatusCode:     statusCode(resp),
+			Duration:       time.Since(start),
+			Err:            err,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g.mintInstallationToken(ctx, client, installation)
+}
+
+// mintInstallationToken creates an installation token for the installation found already.
+func (g *Generator) mintInstallationToken(ctx context.Context, client *github.Client, installation *github.Installation) (*installationToken, error) {
+	opts, err := g.installationTokenOptions(installation)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.checkInstallation(installation, opts); err != nil {
+		return nil, err
+	}
+	if g.preflightPermissions {
+		if err := preflightPermissions(opts.Permissions, installation.GetPermissions()); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		minted *mintedToken
+		out    *github.InstallationToken
+	)
+	err = g.withAttempts(ctx, neverSent, func(ctx context.Context) error {
+		start := time.Now()
+		var (
+			resp *github.Response
+			err  error
+		)
+		minted, resp, err = createInstallationToken(ctx, client, installation.GetID(), opts)
+		if minted != nil {
+			out = &minted.InstallationToken
+		}
+		g.events().TokenMinted(ctx, TokenMintedEvent{
+			InstallationID: installation.GetID(),
+			ExpiresAt:      out.GetExpiresAt(),
+			StatusCode:     statusCode(resp),
+			Duration:       time.Since(start),
+			Err:            err,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Apps.CreateInstallationToken(): %w", classifyAPIError(err))
+	}
+	if g.rawResponse != "" {
+		if err := g.writeRawResponse(minted.body); err != nil {
+			return nil, err
+		}
+	}
+	if g.strict {
+		if err := verifyGrantedScope(opts, minted); err != nil {
+			return nil, err
+		}
+	}
+	token := &installationToken{token: out, installation: installation, repositorySelection: minted.RepositorySelection}
+	if err := g.notifyMinted(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkInstallation refuses an installation outside -allowed-repos or suspended, unless -allow-suspended is given.
+// It runs before minting and before reusing a token under -since-last-run alike.
+func (g *Generator) checkInstallation(installation *github.Installation, opts *github.InstallationTokenOptions) error {
+	if err := g.checkAllowedTargets(installation, opts); err != nil {
+		return err
+	}
+	if suspendedAt := installation.GetSuspendedAt(); !suspendedAt.IsZero() {
+		if !g.allowSuspended {
+			// GitHub refuses to mint usable tokens for an installation suspended by its owner or GitHub.
+			return fmt.Errorf("installation %d is suspended since %s; pass -allow-suspended to try anyway", installation.GetID(), suspendedAt.Format(time.RFC3339))
+		}
+		if err := g.warn("installation %d is suspended since %s; minting anyway as -allow-suspended is set", installation.GetID(), suspendedAt.Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) generateAppToken(ctx context.Context, keySource keySource) ([]byte, error) {
+	start := time.Now()
+	key, cached, err := g.loadKey(keySource)
+	g.events().KeyLoaded(ctx, KeyLoadedEvent{Source: keySource.String(), Cached: cached, Duration: time.Since(start), Err: err})
+	if err != nil {
+		return nil, err
+	}
+	return g.signAppToken(ctx, key)
+}
+
+// issuer returns the iss claim of the app JWT: the client ID when -client-id is given, otherwise the App ID.
+func (g *Generator) issuer() string {
+	if g.clientID != "" {
+		return g.clientID
+	}
+	return strconv.FormatInt(g.appID, 10)
+}
+
+// signAppToken builds the app JWT and signs it with the key's crypto.Signer.
+func (g *Generator) signAppToken(ctx context.Context, key *signingKey) ([]byte, error) {
 	now := time.Now()
-	token, err := jwt.NewBuilder().
-		Issuer(strconv.FormatInt(g.appID, 10)).
-		IssuedAt(now).
-		Expiration(now.Add(g.tokenLiveness)).
-		Build()
+	issuedAt, expiresAt := now.Add(-iatBackdate), now.Add(g.tokenLiveness)
+	if !g.issuedAt.IsZero() {
+		// An explicit iat is not backdated from now, so exp follows from it, as late as GitHub allows, rather than from the clock.
+		issuedAt, expiresAt = g.issuedAt, g.issuedAt.Add(maxAppJWTLiveness)
+	}
+	if !g.expiresAt.IsZero() {
+		expiresAt = g.expiresAt
+	}
+	if !expiresAt.After(issuedAt) {
+		return nil, fmt.Errorf("exp (%s) must be after iat (%s)", expiresAt.Format(time.RFC3339Nano), issuedAt.Format(time.RFC3339Nano))
+	}
+	builder := jwt.NewBuilder().
+		Issuer(g.issuer()).
+		IssuedAt(issuedAt).
+		Expiration(expiresAt)
+	for name, value := range g.jwtClaims {
+		builder = builder.Claim(name, value)
+	}
+	token, err := builder.Build()
 	if err != nil {
 		return nil, fmt.Errorf("jwt.Builder.Build(): %w", err)
 	}
-	return jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if g.minimalClaims {
+		if err := checkMinimalClaims(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	var signOpts []jwt.Option
+	headers := jws.NewHeaders()
+	var customHeaders bool
+	if g.includeKID && key.keyID != "" {
+		if err := headers.Set(jws.KeyIDKey, key.keyID); err != nil {
+			return nil, fmt.Errorf("jws.Headers.Set(): %w", err)
+		}
+		customHeaders = true
+	}
+	if g.jwtTyp != "" {
+		if err := headers.Set(jws.TypeKey, g.jwtTyp); err != nil {
+			return nil, fmt.Errorf("jws.Headers.Set(): %w", err)
+		}
+		customHeaders = true
+	}
+	if customHeaders {
+		signOpts = append(signOpts, jws.WithProtectedHeaders(headers))
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key.signer, signOpts...))
+	g.events().JWTBuilt(ctx, JWTBuiltEvent{AppID: g.appID, Issuer: g.issuer(), IssuedAt: issuedAt, ExpiresAt: expiresAt, Duration: time.Since(now), Err: err})
+	if err != nil {
+		return nil, fmt.Errorf("jwt.Sign(): %w", err)
+	}
+	if g.verifyJWT {
+		// Validation needs jwx v2.0.5 or later: v2.0.4 left a log.Printf in the iat check that wrote to stderr on every -verify-jwt run.
+		if _, err := jwt.Parse(signed, jwt.WithKey(jwa.RS256, key.signer.Public()), jwt.WithValidate(true)); err != nil {
+			return nil, fmt.Errorf("signed JWT does not verify with the public key: %w", err)
+		}
+	}
+	return signed, nil
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// iatBackdate is how far iat is set in the past, as GitHub recommends, so that a clock running ahead of GitHub's does not make the JWT not yet valid.
+const iatBackdate = time.Minute
+
+// maxAppJWTLiveness is the longest -liveness: GitHub accepts exp at most 10 minutes after iat, and iat is backdated by iatBackdate.
+const maxAppJWTLiveness = 10*time.Minute - iatBackdate
+
+// livenessFlag is -liveness: a duration, or max for maxAppJWTLiveness.
+type livenessFlag struct{ d *time.Duration }
+
+func (f livenessFlag) String() string {
+	if f.d == nil {
+		return ""
+	}
+	return f.d.String()
+}
+
+func (f livenessFlag) Set(v string) error {
+	if v == "max" {
+		*f.d = maxAppJWTLiveness
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return errors.New("want a duration such as 5m or max")
+	}
+	*f.d = d
+	return nil
+}
+
+// minimalClaimNames are the claims GitHub requires of an app JWT, and the only ones -minimal-claims lets through.
+var minimalClaimNames = map[string]bool{jwt.IssuerKey: true, jwt.IssuedAtKey: true, jwt.ExpirationKey: true}
+
+// checkMinimalClaims fails when the token carries a claim other than iss, iat and exp.
+func checkMinimalClaims(ctx context.Context, token jwt.Token) error {
+	claims, err := token.AsMap(ctx)
+	if err != nil {
+		return fmt.Errorf("jwt.Token.AsMap(): %w", err)
+	}
+	var extra []string
+	for name := range claims {
+		if !minimalClaimNames[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return fmt.Errorf("-minimal-claims is set but the app JWT has other claims: %s", strings.Join(extra, ", "))
+	}
+	return nil
+}
+
+// claimsFlag collects -jwt-claim name=value. A value that parses as JSON, such as 42, true or {"a":1}, is set as is; any other value is set as a string.
+// iss, iat and exp are refused since -id/-client-id, -iat and -exp already set them.
+type claimsFlag map[string]interface{}
+
+func (f claimsFlag) String() string {
+	return fmt.Sprint(map[string]interface{}(f))
+}
+
+func (f claimsFlag) Set(v string) error {
+	name, raw, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("malformed claim %q; want name=value", v)
+	}
+	switch name {
+	case jwt.IssuerKey, jwt.IssuedAtKey, jwt.ExpirationKey:
+		return fmt.Errorf("claim %s cannot be set by -jwt-claim", name)
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+	f[name] = value
+	return nil
+}
+
+func timeFlag(t *time.Time) func(string) error {
+	return func(s string) error {
+		v, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		*t = v
+		return nil
+	}
 }
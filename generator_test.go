@@ -0,0 +1,166 @@
+package generatetoken
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+func TestIssuer(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		wantIss string
+		wantErr string
+	}{
+		{name: "App ID", args: []string{"-id", "123"}, wantIss: "123"},
+		{name: "client ID", args: []string{"-client-id", "Iv1.0123456789abcdef"}, wantIss: "Iv1.0123456789abcdef"},
+		{name: "newer client ID", args: []string{"-client-id", "Iv23li0123456789abcd"}, wantIss: "Iv23li0123456789abcd"},
+		{name: "both", args: []string{"-id", "123", "-client-id", "Iv1.0123456789abcdef"}, wantErr: "-client-id cannot be combined with -id, -id-fd or -app-slug"},
+		{name: "malformed client ID", args: []string{"-client-id", "123"}, wantErr: "malformed -client-id: 123"},
+		{name: "neither", wantErr: ErrMissingAppID.Error()},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envAppID, "")
+			args := append([]string{"generate-github-app-token", "-private-key", testKeyFile, "-no-network", "-format", "jwt-debug"}, tc.args...)
+			code, stdout, stderr := runCLI(args)
+			if tc.wantErr != "" {
+				if code == 0 || !strings.Contains(stderr, tc.wantErr) {
+					t.Fatalf("Run() = %d with stderr %q, want a failure with %q", code, stderr, tc.wantErr)
+				}
+				return
+			}
+			if code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			var debug struct {
+				Claims map[string]interface{} `json:"claims"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &debug); err != nil {
+				t.Fatal(err)
+			}
+			if iss := debug.Claims["iss"]; iss != tc.wantIss {
+				t.Errorf("iss = %#v, want %q", iss, tc.wantIss)
+			}
+		})
+	}
+}
+
+func TestMinimalClaims(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		wantClaims []string
+		wantErr    string
+	}{
+		{name: "minimal", args: []string{"-minimal-claims"}, wantClaims: []string{"exp", "iat", "iss"}},
+		{name: "minimal with -iat and -exp", args: []string{"-minimal-claims", "-iat", "2030-01-01T00:00:00Z", "-exp", "2030-01-01T00:05:00Z"}, wantClaims: []string{"exp", "iat", "iss"}},
+		{name: "extra claims without -minimal-claims", args: []string{"-jwt-claim", "aud=github"}, wantClaims: []string{"aud", "exp", "iat", "iss"}},
+		{name: "extra claims with -minimal-claims", args: []string{"-minimal-claims", "-jwt-claim", "aud=github"}, wantErr: "-minimal-claims cannot be combined with -jwt-claim"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"generate-github-app-token", "-id", "123", "-private-key", testKeyFile, "-no-network", "-format", "jwt-debug"}, tc.args...)
+			code, stdout, stderr := runCLI(args)
+			if tc.wantErr != "" {
+				if code == 0 || !strings.Contains(stderr, tc.wantErr) {
+					t.Fatalf("Run() = %d with stderr %q, want a failure with %q", code, stderr, tc.wantErr)
+				}
+				return
+			}
+			if code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			var debug struct {
+				Claims map[string]interface{} `json:"claims"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &debug); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for name := range debug.Claims {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.wantClaims) {
+				t.Errorf("claims = %v, want %v", got, tc.wantClaims)
+			}
+		})
+	}
+}
+
+func TestCheckMinimalClaims(t *testing.T) {
+	cases := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr string
+	}{
+		{name: "iss, iat and exp", claims: map[string]interface{}{"iss": "123", "iat": 1, "exp": 2}},
+		{name: "extra claims", claims: map[string]interface{}{"iss": "123", "iat": 1, "exp": 2, "nbf": 1, "aud": "github"}, wantErr: "-minimal-claims is set but the app JWT has other claims: aud, nbf"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := jwt.New()
+			for name, value := range tc.claims {
+				if err := token.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := checkMinimalClaims(context.Background(), token)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkMinimalClaims(): %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("checkMinimalClaims() = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestExplicitIssuedAt(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cases := []struct {
+		name    string
+		args    []string
+		wantIat time.Time
+		wantExp time.Time
+	}{
+		{name: "future -iat", args: []string{"-iat", now.Add(time.Hour).Format(time.RFC3339)}, wantIat: now.Add(time.Hour), wantExp: now.Add(time.Hour + maxAppJWTLiveness)},
+		{name: "past -iat", args: []string{"-iat", now.Add(-time.Hour).Format(time.RFC3339)}, wantIat: now.Add(-time.Hour), wantExp: now.Add(-time.Hour + maxAppJWTLiveness)},
+		{name: "-iat and -exp", args: []string{"-iat", now.Format(time.RFC3339), "-exp", now.Add(3 * time.Minute).Format(time.RFC3339)}, wantIat: now, wantExp: now.Add(3 * time.Minute)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"generate-github-app-token", "-id", "123", "-private-key", testKeyFile, "-no-network", "-format", "jwt-debug"}, tc.args...)
+			code, stdout, stderr := runCLI(args)
+			if code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			var debug struct {
+				Claims struct {
+					IssuedAt   int64 `json:"iat"`
+					Expiration int64 `json:"exp"`
+				} `json:"claims"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &debug); err != nil {
+				t.Fatal(err)
+			}
+			if got := time.Unix(debug.Claims.IssuedAt, 0); !got.Equal(tc.wantIat) {
+				t.Errorf("iat = %s, want %s", got, tc.wantIat)
+			}
+			if got := time.Unix(debug.Claims.Expiration, 0); !got.Equal(tc.wantExp) {
+				t.Errorf("exp = %s, want %s", got, tc.wantExp)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package generatetoken
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envGHHost names the host gh works against when hosts.yml lists several; -from-gh-config honors it the same way.
+const envGHHost = "GH_HOST"
+
+// ghHostsPath returns the hosts.yml of the gh CLI following gh's own lookup: GH_CONFIG_DIR, XDG_CONFIG_HOME/gh, then ~/.config/gh.
+func ghHostsPath() (string, error) {
+	if dir := os.Getenv("GH_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "hosts.yml"), nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gh", "hosts.yml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("os.UserHomeDir(): %w", err)
+	}
+	return filepath.Join(home, ".config", "gh", "hosts.yml"), nil
+}
+
+// parseGHHosts returns the hosts hosts.yml lists. Only the top-level keys are read, so no YAML parser is needed:
+//
+//	github.com:
+//	    user: octocat
+//	ghe.example.com:
+//	    user: octocat
+func parseGHHosts(b []byte) []string {
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || !strings.HasSuffix(line, ":") {
+			continue
+		}
+		hosts = append(hosts, strings.Trim(strings.TrimSuffix(line, ":"), `"'`))
+	}
+	return hosts
+}
+
+// baseURLFromGHConfig picks the GitHub Enterprise Server host gh is logged in to and returns its API base URL.
+// It returns the empty string to stay on github.com when hosts.yml is absent or lists no other host;
+// when it lists several, GH_HOST chooses among them.
+func (g *Generator) baseURLFromGHConfig() (string, error) {
+	path, err := ghHostsPath()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		g.logf("-from-gh-config: %s does not exist; using github.com", path)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ioutil.ReadFile(%s): %w", path, err)
+	}
+	var enterprise []string
+	for _, host := range parseGHHosts(b) {
+		if host != "github.com" {
+			enterprise = append(enterprise, host)
+		}
+	}
+	host := os.Getenv(envGHHost)
+	switch {
+	case host != "":
+	case len(enterprise) == 0:
+		g.logf("-from-gh-config: %s lists no GitHub Enterprise Server host; using github.com", path)
+		return "", nil
+	case len(enterprise) == 1:
+		host = enterprise[0]
+	default:
+		return "", fmt.Errorf("-from-gh-config: %s lists several hosts (%s); choose one with %s or -base-url", path, strings.Join(enterprise, ", "), envGHHost)
+	}
+	if host == "github.com" {
+		return "", nil
+	}
+	g.logf("-from-gh-config: using %s from %s", host, path)
+	return "https://" + host + "/api/v3/", nil
+}
@@ -0,0 +1,64 @@
+package generatetoken
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// envGitHubOutput names the file GitHub Actions reads step outputs from.
+const envGitHubOutput = "GITHUB_OUTPUT"
+
+var unsafeOutputNameChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// gitHubOutputs returns the step outputs -github-output sets, in order:
+//
+//	token, expires_at, installation_id, account_login and one permission_<name>=<level> for each permission the token was granted.
+//
+// Permission names are lowercased and characters Actions does not allow in an output name become underscores.
+func (g *Generator) gitHubOutputs(out *tokenOutput) ([][2]string, error) {
+	outputs := [][2]string{{"token", out.Token}}
+	if out.ExpiresAt != nil {
+		outputs = append(outputs, [2]string{"expires_at", out.ExpiresAt.Format(time.RFC3339)})
+	}
+	if out.InstallationID == 0 {
+		return outputs, nil
+	}
+	outputs = append(outputs, [2]string{"installation_id", fmt.Sprint(out.InstallationID)}, [2]string{"account_login", out.AccountLogin})
+	perms, err := permissionsToMap(out.permissions)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedPermissionNames(perms) {
+		outputs = append(outputs, [2]string{"permission_" + unsafeOutputNameChars.ReplaceAllString(strings.ToLower(name), "_"), perms[name]})
+	}
+	return outputs, nil
+}
+
+// writeGitHubOutput appends the step outputs to the file named by GITHUB_OUTPUT.
+func (g *Generator) writeGitHubOutput(out *tokenOutput) error {
+	path := os.Getenv(envGitHubOutput)
+	if path == "" {
+		return errors.New("-github-output requires GITHUB_OUTPUT, which GitHub Actions sets for every step")
+	}
+	outputs, err := g.gitHubOutputs(out)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, output := range outputs {
+		fmt.Fprintf(&b, "%s=%s\n", output[0], output[1])
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(%s): %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		return fmt.Errorf("write(%s): %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package generatetoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// installationCache remembers the installations looked up during one process so that -batch-file entries sharing a target look it up once.
+// Copies of a Generator made for batch entries share it.
+type installationCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedInstallation
+}
+
+// cachedInstallation is locked while its installation is looked up so that concurrent lookups of the same target wait for the first one.
+type cachedInstallation struct {
+	mu           sync.Mutex
+	installation *github.Installation
+}
+
+func (c *installationCache) entry(key string) *cachedInstallation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*cachedInstallation{}
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cachedInstallation{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// installationCacheKey identifies the target of the lookup; the App and the API host are part of it since they change the answer.
+func (g *Generator) installationCacheKey() string {
+	target := "repo:" + strings.ToLower(g.installedRepository)
+	if g.account != "" {
+		target = "account:" + strings.ToLower(g.account)
+	}
+	return g.baseURL + " " + g.issuer() + " " + target
+}
+
+// findInstallation looks up the installation of the App for the target given by -repo or -account.
+// Successful lookups are cached for the rest of the process; failures are not, so that -attempts retries them.
+func (g *Generator) findInstallation(ctx context.Context, client *github.Client) (*github.Installation, *github.Response, error) {
+	e := g.installations.entry(g.installationCacheKey())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.installation != nil {
+		g.logf("using the installation %d looked up before", e.installation.GetID())
+		return e.installation, nil, nil
+	}
+	installation, resp, err := g.lookupInstallation(ctx, client)
+	if err != nil {
+		return nil, resp, err
+	}
+	e.installation = installation
+	return installation, resp, nil
+}
+
+func (g *Generator) lookupInstallation(ctx context.Context, client *github.Client) (*github.Installation, *github.Response, error) {
+	if g.account != "" {
+		return findAccountInstallation(ctx, client, g.account)
+	}
+	owner, repo, found := strings.Cut(g.installedRepository, "/")
+	if !found {
+		return nil, nil, fmt.Errorf("malformed repository name: %s", g.installedRepository)
+	}
+	installation, resp, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return nil, resp, fmt.Errorf("Apps.FindRepositoryInstallation(): %w", classifyAPIError(err))
+	}
+	return installation, resp, nil
+}
+
+// findAccountInstallation looks up the installation on an organization first and falls back to a user of the same name.
+func findAccountInstallation(ctx context.Context, client *github.Client, account string) (*github.Installation, *github.Response, error) {
+	installation, resp, err := client.Apps.FindOrganizationInstallation(ctx, account)
+	if err == nil {
+		return installation, resp, nil
+	}
+	if !isNotFound(err) {
+		return nil, resp, fmt.Errorf("Apps.FindOrganizationInstallation(): %w", classifyAPIError(err))
+	}
+	installation, resp, err = client.Apps.FindUserInstallation(ctx, account)
+	if isNotFound(err) {
+		return nil, resp, fmt.Errorf("the App is not installed on an organization or user named %s", account)
+	}
+	if err != nil {
+		return nil, resp, fmt.Errorf("Apps.FindUserInstallation(): %w", classifyAPIError(err))
+	}
+	return installation, resp, nil
+}
+
+// resolveAppSlug looks up the App by its slug on the public API and returns its ID.
+// When -id is also given, the slug must belong to that App.
+func (g *Generator) resolveAppSlug(ctx context.Context) (int64, error) {
+	client, err := g.newClient(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	app, _, err := client.Apps.Get(ctx, g.appSlug)
+	if isNotFound(err) {
+		return 0, fmt.Errorf("no App found with slug %s", g.appSlug)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Apps.Get(%s): %w", g.appSlug, classifyAPIError(err))
+	}
+	if g.appID != 0 && app.GetID() != g.appID {
+		return 0, fmt.Errorf("App slug %s belongs to App %d, not %d", g.appSlug, app.GetID(), g.appID)
+	}
+	if app.GetID() == 0 {
+		return 0, errors.New("GitHub returned the App without an ID")
+	}
+	return app.GetID(), nil
+}
+
+func isNotFound(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
+
+// appClient returns a client authenticated as the App with the first private key.
+func (g *Generator) appClient(ctx context.Context) (*github.Client, error) {
+	appToken, err := g.generateAppToken(ctx, g.privateKeys[0])
+	if err != nil {
+		return nil, fmt.Errorf("generateAuthToken(): %w", err)
+	}
+	return g.newClient(ctx, string(appToken))
+}
+
+// showPermissions prints the permissions the installation grants the App, i.e. the values -permission may request.
+func (g *Generator) showPermissions(ctx context.Context) error {
+	client, err := g.appClient(ctx)
+	if err != nil {
+		return err
+	}
+	installation, _, err := g.findInstallation(ctx, client)
+	if err != nil {
+		return err
+	}
+	perms, err := permissionsToMap(installation.GetPermissions())
+	if err != nil {
+		return err
+	}
+	if g.outputFormat == formatJSON {
+		body, err := marshalJSON(struct {
+			InstallationID int64             `json:"installation_id"`
+			Permissions    map[string]string `json:"permissions"`
+		}{installation.GetID(), perms})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(g.outStream, body)
+		return nil
+	}
+	for _, name := range sortedPermissionNames(perms) {
+		fmt.Fprintf(g.outStream, "%s: %s\n", name, perms[name])
+	}
+	return nil
+}
+
+// showSingleFile prints the single-file settings of the installation: the level of the single_file permission and the files it covers.
+// Contents access of an installation with them is limited to those files, which tokens minted for it inherit.
+func (g *Generator) showSingleFile(ctx context.Context) error {
+	client, err := g.appClient(ctx)
+	if err != nil {
+		return err
+	}
+	installation, _, err := g.findInstallation(ctx, client)
+	if err != nil {
+		return err
+	}
+	paths := installation.SingleFilePaths
+	if len(paths) == 0 && installation.GetSingleFileName() != "" {
+		paths = []string{installation.GetSingleFileName()}
+	}
+	level := installation.GetPermissions().GetSingleFile()
+	if g.outputFormat == formatJSON {
+		body, err := marshalJSON(struct {
+			InstallationID  int64    `json:"installation_id"`
+			SingleFile      string   `json:"single_file,omitempty"`
+			SingleFileName  string   `json:"single_file_name,omitempty"`
+			SingleFilePaths []string `json:"single_file_paths"`
+		}{installation.GetID(), level, installation.GetSingleFileName(), paths})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(g.outStream, body)
+		return nil
+	}
+	if level == "" && len(paths) == 0 {
+		fmt.Fprintf(g.outStream, "installation %d has no single-file permission\n", installation.GetID())
+		return nil
+	}
+	if level != "" {
+		fmt.Fprintf(g.outStream, "single_file: %s\n", level)
+	}
+	for _, path := range paths {
+		fmt.Fprintf(g.outStream, "path: %s\n", path)
+	}
+	return nil
+}
+
+// verifyRepositoryAccess fetches the -verify-access repository with the minted token to confirm the token reaches it.
+func (g *Generator) verifyRepositoryAccess(ctx context.Context, token string) error {
+	owner, repo, found := strings.Cut(g.verifyAccess, "/")
+	if !found {
+		return fmt.Errorf("malformed -verify-access: %s", g.verifyAccess)
+	}
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return err
+	}
+	_, resp, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		if code := statusCode(resp); code == http.StatusForbidden || code == http.StatusNotFound {
+			return &noAccessError{repository: g.verifyAccess, statusCode: code, err: err}
+		}
+		return fmt.Errorf("Repositories.Get(): %w", classifyAPIError(err))
+	}
+	g.logf("verified the minted token can access %s", g.verifyAccess)
+	return nil
+}
+
+// repositorySelectionSelected is the repository_selection of a token restricted to the repositories it was requested for.
+const repositorySelectionSelected = "selected"
+
+// mintedToken is the create-installation-token response. go-github's InstallationToken lacks repository_selection, so it is decoded here alongside.
+type mintedToken struct {
+	github.InstallationToken
+	RepositorySelection string `json:"repository_selection,omitempty"`
+	// body is the response body as GitHub sent it, for -raw-response.
+	body json.RawMessage
+}
+
+// createInstallationToken is Apps.CreateInstallationToken that also returns the repository_selection of the minted token.
+func createInstallationToken(ctx context.Context, client *github.Client, id int64, opts *github.InstallationTokenOptions) (*mintedToken, *github.Response, error) {
+	req, err := client.NewRequest(http.MethodPost, fmt.Sprintf("app/installations/%v/access_tokens", id), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var body json.RawMessage
+	resp, err := client.Do(ctx, req, &body)
+	if err != nil {
+		return nil, resp, err
+	}
+	token := &mintedToken{body: body}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, resp, fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+	return token, resp, nil
+}
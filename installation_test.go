@@ -0,0 +1,66 @@
+package generatetoken
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInstallationLookupCached(t *testing.T) {
+	cases := []struct {
+		name        string
+		mint        func(t *testing.T, m *mockGitHub)
+		wantLookups int
+		wantMints   int
+	}{
+		{
+			name: "batch entries sharing a repository",
+			mint: func(t *testing.T, m *mockGitHub) {
+				if code, _, stderr := runCLI([]string{"generate-github-app-token", "-base-url", m.URL + "/", "-batch-file", writeBatchFile(t, 3, "acme/api")}); code != 0 {
+					t.Fatalf("Run() = %d: %s", code, stderr)
+				}
+			},
+			wantLookups: 1,
+			wantMints:   3,
+		},
+		{
+			name: "repeated calls of one Generator",
+			mint: func(t *testing.T, m *mockGitHub) {
+				g := newTestGenerator(WithBaseURL(m.URL+"/"), WithRepository("acme/api"))
+				for i := 0; i < 3; i++ {
+					if _, err := g.GenerateInstallationToken(context.Background()); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			wantLookups: 1,
+			wantMints:   3,
+		},
+		{
+			name: "different repositories",
+			mint: func(t *testing.T, m *mockGitHub) {
+				for _, repo := range []string{"acme/api", "acme/web"} {
+					g := newTestGenerator(WithBaseURL(m.URL+"/"), WithRepository(repo))
+					if _, err := g.GenerateInstallationToken(context.Background()); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			wantLookups: 2,
+			wantMints:   2,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			tc.mint(t, m)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.lookups != tc.wantLookups {
+				t.Errorf("%d installation lookups, want %d", m.lookups, tc.wantLookups)
+			}
+			if m.mints != tc.wantMints {
+				t.Errorf("%d tokens minted, want %d", m.mints, tc.wantMints)
+			}
+		})
+	}
+}
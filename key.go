@@ -0,0 +1,395 @@
+package generatetoken
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// defaultMinKeyBits is the size of the keys GitHub generates for Apps.
+const defaultMinKeyBits = 2048
+
+// keySource is a place a private key is read from.
+// String names the source for diagnostics and must never contain key material.
+type keySource interface {
+	// String names the source in diagnostics.
+	fmt.Stringer
+	// cacheKey tells sources apart for the parsed key cache, even those String names alike.
+	cacheKey() string
+	readKey() ([]byte, error)
+}
+
+type fileKeySource string
+
+func (s fileKeySource) String() string {
+	return string(s)
+}
+
+func (s fileKeySource) cacheKey() string {
+	return "file:" + string(s)
+}
+
+func (s fileKeySource) readKey() ([]byte, error) {
+	rawKey, err := ioutil.ReadFile(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", string(s), err)
+	}
+	return rawKey, nil
+}
+
+// signerKeySource is a crypto.Signer given by WithSigner, such as a key held by an HSM or a cloud KMS.
+// loadKey returns it as is; it has no bytes to read.
+type signerKeySource struct {
+	key *signingKey
+}
+
+func (s *signerKeySource) String() string {
+	if s.key.keyID != "" {
+		return "signer " + s.key.keyID
+	}
+	return "signer"
+}
+
+func (s *signerKeySource) cacheKey() string {
+	return fmt.Sprintf("signer:%p", s)
+}
+
+func (s *signerKeySource) readKey() ([]byte, error) {
+	return nil, errors.New("a crypto.Signer has no key to read")
+}
+
+// keyFetchTimeout bounds fetching a private key from an https:// URL.
+const keyFetchTimeout = 30 * time.Second
+
+// urlKeySource fetches the PEM or JWK from an https:// URL, e.g. an internal secret endpoint.
+// client is called when the key is read so that the client reflects flags parsed after this source, such as -ip-version.
+type urlKeySource struct {
+	url    *url.URL
+	client func() *http.Client
+}
+
+// String leaves out the user info and the query, which may carry credentials of the endpoint.
+func (s *urlKeySource) String() string {
+	return (&url.URL{Scheme: s.url.Scheme, Host: s.url.Host, Path: s.url.Path}).String()
+}
+
+// cacheKey is the URL in full, as URLs differing only in the query or the user info may serve different keys.
+func (s *urlKeySource) cacheKey() string {
+	return s.url.String()
+}
+
+func (s *urlKeySource) readKey() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest(%s): %w", s, err)
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		// The error quotes the URL in full; name the source instead.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to fetch the private key from %s: %w", s, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the private key from %s: %s", s, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the private key from %s: %w", s, err)
+	}
+	return b, nil
+}
+
+// keyFetchClient is the client urlKeySource fetches with: the one the API calls use, minus the custom headers, which are meant for GitHub,
+// and refusing redirects to anything but https://. Proxy and CA settings of the environment apply as for the API calls.
+func (g *Generator) keyFetchClient() *http.Client {
+	client := g.dialingClient()
+	if client == nil {
+		client = &http.Client{}
+	}
+	client.Timeout = keyFetchTimeout
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing to follow a redirect to a %s URL", req.URL.Scheme)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return client
+}
+
+// newKeySource returns the source of -private-key and the other private key locations: a path, a file:// URL, or an https:// URL fetched with client.
+func newKeySource(location string, client func() *http.Client) (keySource, error) {
+	if !strings.Contains(location, "://") {
+		return fileKeySource(location), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.New("malformed private key URL")
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file:// private key URL must not name a host: %s", u.Host)
+		}
+		return fileKeySource(u.Path), nil
+	case "https":
+		return &urlKeySource{url: u, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key URL scheme %q; use a path, file:// or https://", u.Scheme)
+	}
+}
+
+// fetchesOverNetwork reports whether any of the keys is fetched from an https:// URL.
+func (s keySources) fetchesOverNetwork() bool {
+	for _, src := range s {
+		if _, ok := src.(*urlKeySource); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fdKeySource reads the key from an inherited file descriptor so that a parent process can hand it over without exposing it in argv or the environment.
+type fdKeySource int
+
+func (s fdKeySource) String() string {
+	return fmt.Sprintf("fd:%d", int(s))
+}
+
+func (s fdKeySource) cacheKey() string {
+	return s.String()
+}
+
+func (s fdKeySource) readKey() ([]byte, error) {
+	return readFD(int(s))
+}
+
+func readFD(fd int) ([]byte, error) {
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd:%d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	defer f.Close()
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not open: %w", fd, err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file descriptor %d: %w", fd, err)
+	}
+	return b, nil
+}
+
+func readAppIDFromFD(fd int) (int64, error) {
+	b, err := readFD(fd)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed App ID read from file descriptor %d: %w", fd, err)
+	}
+	return id, nil
+}
+
+type keySources []keySource
+
+func (s keySources) names() []string {
+	names := make([]string, len(s))
+	for i, src := range s {
+		names[i] = src.String()
+	}
+	return names
+}
+
+// privateKeySourcesFlag appends the source of each -private-key; see newKeySource.
+type privateKeySourcesFlag struct {
+	sources *keySources
+	client  func() *http.Client
+}
+
+func (f privateKeySourcesFlag) String() string {
+	if f.sources == nil {
+		return ""
+	}
+	return strings.Join(f.sources.names(), ",")
+}
+
+func (f privateKeySourcesFlag) Set(v string) error {
+	source, err := newKeySource(v, f.client)
+	if err != nil {
+		return err
+	}
+	*f.sources = append(*f.sources, source)
+	return nil
+}
+
+// fdKeySourcesFlag appends a fdKeySource for each -private-key-fd.
+type fdKeySourcesFlag struct{ sources *keySources }
+
+func (f fdKeySourcesFlag) String() string {
+	if f.sources == nil {
+		return ""
+	}
+	return strings.Join(f.sources.names(), ",")
+}
+
+func (f fdKeySourcesFlag) Set(v string) error {
+	fd, err := strconv.Atoi(v)
+	if err != nil || fd < 0 {
+		return fmt.Errorf("invalid file descriptor: %s", v)
+	}
+	*f.sources = append(*f.sources, fdKeySource(fd))
+	return nil
+}
+
+// signingKey is the signer of the app JWT along with the key ID the JWK carried, if any.
+// The signer is a crypto.Signer so that keys which never leave their backend (HSM, KMS) can sign as well as software keys.
+type signingKey struct {
+	signer crypto.Signer
+	keyID  string
+}
+
+// keyCache holds parsed keys by the cacheKey of their source.
+// It is shared by reference so that Generators cloned for batch entries reuse each other's parsed keys.
+type keyCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedKey
+}
+
+// cachedKey is locked while its key is read, so that concurrent loads of the same source wait for the first one
+// while loads of other sources, a slow https:// fetch among them, go ahead.
+type cachedKey struct {
+	mu  sync.Mutex
+	key *signingKey
+}
+
+func (c *keyCache) entry(key string) *cachedKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*cachedKey{}
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cachedKey{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// loadKey reads and parses the private key from the source and reports whether it came from the cache.
+// Parsed keys are cached on the Generator so that minting many tokens does not re-parse the same key.
+func (g *Generator) loadKey(source keySource) (*signingKey, bool, error) {
+	if s, ok := source.(*signerKeySource); ok {
+		// There is nothing to read or parse; the signer is checked like a parsed key every time it is used.
+		if err := g.checkSigner(source, s.key.signer); err != nil {
+			return nil, false, err
+		}
+		return s.key, false, nil
+	}
+	e := g.parsedKeys.entry(source.cacheKey())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.key != nil {
+		return e.key, true, nil
+	}
+	rawKey, err := source.readKey()
+	if err != nil {
+		return nil, false, err
+	}
+	combinedKey, err := g.parseKey(rawKey)
+	if err != nil {
+		return nil, false, err
+	}
+	var raw interface{}
+	if err := combinedKey.Raw(&raw); err != nil {
+		return nil, false, fmt.Errorf("jwk.Key.Raw(): %w", err)
+	}
+	signer, ok := raw.(crypto.Signer)
+	if !ok {
+		return nil, false, fmt.Errorf("%s is not a private key", source)
+	}
+	if err := g.checkSigner(source, signer); err != nil {
+		return nil, false, err
+	}
+	e.key = &signingKey{signer: signer, keyID: combinedKey.KeyID()}
+	return e.key, false, nil
+}
+
+// checkSigner requires the signer to hold an RSA key of at least -min-key-bits, or only warns about a shorter key without -strict-key.
+func (g *Generator) checkSigner(source keySource, signer crypto.Signer) error {
+	pub, ok := signer.Public().(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%s is not an RSA key; GitHub requires RS256", source)
+	}
+	if bits := pub.N.BitLen(); bits < g.minKeyBits {
+		if g.strictKey {
+			return fmt.Errorf("%s is a %d-bit RSA key; -min-key-bits requires at least %d", source, bits, g.minKeyBits)
+		}
+		if err := g.warn("%s is a %d-bit RSA key, shorter than -min-key-bits %d", source, bits, g.minKeyBits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseKey parses rawKey either as PEM or, when it looks like JSON, as a JWK or JWK Set.
+// A set holding more than one key requires -kid to select the signing key.
+func (g *Generator) parseKey(rawKey []byte) (jwk.Key, error) {
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(rawKey), []byte("{"))
+	set, err := jwk.Parse(rawKey, jwk.WithPEM(!isJSON))
+	if err != nil {
+		return nil, fmt.Errorf("jwk.Parse(): %w", err)
+	}
+	if g.keyID == "" {
+		switch set.Len() {
+		case 0:
+			return nil, errors.New("no key found")
+		case 1:
+			key, _ := set.Key(0)
+			return key, nil
+		default:
+			return nil, fmt.Errorf("key set contains %d keys; -kid is required to select one", set.Len())
+		}
+	}
+	var found jwk.Key
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if key.KeyID() != g.keyID {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("kid %q is ambiguous: more than one key has it", g.keyID)
+		}
+		found = key
+	}
+	if found == nil {
+		return nil, fmt.Errorf("kid %q not found in key set", g.keyID)
+	}
+	return found, nil
+}
@@ -0,0 +1,193 @@
+package generatetoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// testKeyFile is a 2048-bit RSA key used only by the tests.
+const testKeyFile = "testdata/key.pem"
+
+func newTestGenerator(opts ...Option) *Generator {
+	return NewGenerator(ioutil.Discard, ioutil.Discard, append([]Option{WithAppID(123), WithPrivateKeyFile(testKeyFile)}, opts...)...)
+}
+
+func BenchmarkGenerateAppToken(b *testing.B) {
+	ctx := context.Background()
+	b.Run("cached key", func(b *testing.B) {
+		g := newTestGenerator()
+		for i := 0; i < b.N; i++ {
+			if _, err := g.generateAppToken(ctx, g.privateKeys[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parsed every time", func(b *testing.B) {
+		g := newTestGenerator()
+		for i := 0; i < b.N; i++ {
+			g.parsedKeys = &keyCache{}
+			if _, err := g.generateAppToken(ctx, g.privateKeys[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// otherKeyFile is a key that does not belong to App 123, for the key rotation tests.
+const otherKeyFile = "testdata/other.pem"
+
+func TestVerifyAppFallsThroughToNextKey(t *testing.T) {
+	pub, err := ioutil.ReadFile("testdata/key.pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := jwk.ParseKey(pub, jwk.WithPEM(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name         string
+		keys         []string
+		otherApp     bool
+		wantCode     int
+		wantErr      string
+		wantAppCalls int
+	}{
+		{name: "first key rejected with 401", keys: []string{otherKeyFile, testKeyFile}, wantCode: 0, wantAppCalls: 2},
+		{name: "first key of another App", keys: []string{otherKeyFile, testKeyFile}, otherApp: true, wantCode: 0, wantAppCalls: 2},
+		{name: "first key valid", keys: []string{testKeyFile, otherKeyFile}, wantCode: 0, wantAppCalls: 1},
+		{name: "every key rejected", keys: []string{otherKeyFile, otherKeyFile}, wantCode: 1, wantErr: "private key does not match App 123", wantAppCalls: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			// GitHub knows App 123 by testKeyFile only; otherKeyFile gets a 401, or belongs to App 456 when otherApp is set.
+			m.app = func(w http.ResponseWriter, r *http.Request) {
+				if _, err := jwt.ParseHeader(r.Header, "Authorization", jwt.WithKey(jwa.RS256, verifier)); err == nil {
+					writeJSON(w, http.StatusOK, &github.App{ID: github.Int64(123)})
+					return
+				}
+				if tc.otherApp {
+					writeJSON(w, http.StatusOK, &github.App{ID: github.Int64(456)})
+					return
+				}
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "A JSON web token could not be decoded"})
+			}
+			args := []string{"generate-github-app-token", "-id", "123", "-base-url", m.URL + "/", "-repo", "acme/api", "-verify-app"}
+			for _, key := range tc.keys {
+				args = append(args, "-private-key", key)
+			}
+			code, stdout, stderr := runCLI(args)
+			if code != tc.wantCode {
+				t.Fatalf("Run() = %d, want %d: %s", code, tc.wantCode, stderr)
+			}
+			if tc.wantErr != "" && !strings.Contains(stderr, tc.wantErr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tc.wantErr)
+			}
+			if tc.wantCode == 0 && stdout != "ghs_mock\n" {
+				t.Errorf("stdout = %q, want the token", stdout)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.appCalls != tc.wantAppCalls {
+				t.Errorf("GET /app called %d times, want %d", m.appCalls, tc.wantAppCalls)
+			}
+		})
+	}
+}
+
+// stubSigner signs with a software key and counts the digests it is asked to sign, standing in for an HSM or a KMS.
+type stubSigner struct {
+	crypto.Signer
+	digests int
+}
+
+func (s *stubSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.digests++
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestWithSigner(t *testing.T) {
+	rawKey, err := ioutil.ReadFile(testKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rawKey)
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		signer  crypto.Signer
+		kid     string
+		wantKID string
+		wantErr string
+	}{
+		{name: "RSA signer", signer: parsed.(crypto.Signer)},
+		{name: "RSA signer with kid", signer: parsed.(crypto.Signer), kid: "hsm-1", wantKID: "hsm-1"},
+		{name: "non-RSA signer", signer: ecKey, wantErr: "signer is not an RSA key; GitHub requires RS256"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			var gotKID string
+			m.mint = func(w http.ResponseWriter, r *http.Request, opts *github.InstallationTokenOptions) {
+				// GitHub verifies the app JWT with the public key it knows for the App.
+				token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if _, err := jws.Verify([]byte(token), jws.WithKey(jwa.RS256, parsed.(crypto.Signer).Public())); err != nil {
+					writeJSON(w, http.StatusUnauthorized, map[string]string{"message": err.Error()})
+					return
+				}
+				msg, err := jws.Parse([]byte(token))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				gotKID = msg.Signatures()[0].ProtectedHeaders().KeyID()
+				mintToken(w, r, opts, "")
+			}
+			stub := &stubSigner{Signer: tc.signer}
+			g := NewGenerator(ioutil.Discard, ioutil.Discard, WithAppID(123), WithSigner(stub, tc.kid), WithBaseURL(m.URL+"/"), WithRepository("acme/api"))
+			token, err := g.GenerateInstallationToken(context.Background())
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("GenerateInstallationToken() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.GetToken() != "ghs_mock" {
+				t.Errorf("token = %q, want ghs_mock", token.GetToken())
+			}
+			if stub.digests == 0 {
+				t.Error("the signer was never asked to sign")
+			}
+			if gotKID != tc.wantKID {
+				t.Errorf("kid = %q, want %q", gotKID, tc.wantKID)
+			}
+		})
+	}
+}
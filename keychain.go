@@ -0,0 +1,39 @@
+//go:build keychain
+
+package generatetoken
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// storeInKeychain writes the token to the platform secret store.
+//
+// macOS uses the login Keychain through security(1) and Linux uses the Secret Service through secret-tool(1).
+// The token is passed on stdin in both cases so that it never shows up in the process list.
+func storeInKeychain(service, account, token string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", securityQuote(service), securityQuote(account), securityQuote(token)))
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label", service, "service", service, "account", account)
+		cmd.Stdin = strings.NewReader(token)
+	default:
+		return fmt.Errorf("keychain is not supported on %s", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
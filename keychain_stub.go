@@ -0,0 +1,9 @@
+//go:build !keychain
+
+package generatetoken
+
+import "errors"
+
+func storeInKeychain(service, account, token string) error {
+	return errors.New("-keychain-service is not available; rebuild with -tags keychain")
+}
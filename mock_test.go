@@ -0,0 +1,173 @@
+package generatetoken
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// mockGitHub stands in for the GitHub Enterprise Server API the tests pass to -base-url.
+// It serves the repository installation lookup, the installation list and token creation, and counts the calls.
+type mockGitHub struct {
+	*httptest.Server
+
+	// installations is what GET /app/installations lists; every repository belongs to installation 42 whatever it holds.
+	installations []*github.Installation
+	// app answers GET /app, which -verify-app calls, instead of reporting App 123 when set.
+	app func(w http.ResponseWriter, r *http.Request)
+	// lookup answers the repository installation lookup instead of installation 42 when set.
+	lookup func(w http.ResponseWriter, r *http.Request)
+	// mint answers token creation instead of mintToken when set.
+	mint func(w http.ResponseWriter, r *http.Request, opts *github.InstallationTokenOptions)
+	// mintDelay holds each token creation in flight for this long.
+	mintDelay time.Duration
+
+	mu          sync.Mutex
+	requests    int
+	appCalls    int
+	lookups     int
+	mints       int
+	inFlight    int
+	maxInFlight int
+}
+
+func newMockGitHub(t *testing.T) *mockGitHub {
+	t.Helper()
+	m := &mockGitHub{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app", m.serveApp)
+	mux.HandleFunc("/api/v3/repos/", m.serveRepositoryInstallation)
+	mux.HandleFunc("/api/v3/app/installations", m.serveInstallations)
+	mux.HandleFunc("/api/v3/app/installations/", m.serveAccessTokens)
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.requests++
+		m.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// args returns the flags authenticating as App 123 with the test key against the mock, followed by extra.
+func (m *mockGitHub) args(extra ...string) []string {
+	return append([]string{"generate-github-app-token", "-id", "123", "-private-key", testKeyFile, "-base-url", m.URL + "/"}, extra...)
+}
+
+func (m *mockGitHub) serveApp(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.appCalls++
+	m.mu.Unlock()
+	if m.app != nil {
+		m.app(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.App{ID: github.Int64(123)})
+}
+
+func (m *mockGitHub) serveRepositoryInstallation(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v3/repos/")
+	owner, rest, _ := strings.Cut(path, "/")
+	if !strings.HasSuffix(rest, "/installation") {
+		http.NotFound(w, r)
+		return
+	}
+	m.mu.Lock()
+	m.lookups++
+	m.mu.Unlock()
+	if m.lookup != nil {
+		m.lookup(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.Installation{
+		ID:          github.Int64(42),
+		Account:     &github.User{Login: github.String(owner), Type: github.String("Organization")},
+		Permissions: &github.InstallationPermissions{Contents: github.String("write"), Metadata: github.String("read")},
+	})
+}
+
+func (m *mockGitHub) serveInstallations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.installations)
+}
+
+func (m *mockGitHub) serveAccessTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/access_tokens") {
+		http.NotFound(w, r)
+		return
+	}
+	m.mu.Lock()
+	m.mints++
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+	time.Sleep(m.mintDelay)
+	var opts github.InstallationTokenOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if m.mint != nil {
+		m.mint(w, r, &opts)
+		return
+	}
+	mintToken(w, r, &opts, "")
+}
+
+// mintToken answers a token scoped as requested; a non-empty selection overrides the repository_selection GitHub would report.
+func mintToken(w http.ResponseWriter, r *http.Request, opts *github.InstallationTokenOptions, selection string) {
+	body := map[string]interface{}{
+		"token":      "ghs_mock",
+		"expires_at": "2030-01-01T00:00:00Z",
+	}
+	if opts.Permissions != nil {
+		body["permissions"] = opts.Permissions
+	}
+	if selection == "" {
+		selection = "all"
+		if len(opts.Repositories) > 0 {
+			selection = "selected"
+		}
+	}
+	body["repository_selection"] = selection
+	var repos []map[string]string
+	for _, name := range opts.Repositories {
+		repos = append(repos, map[string]string{"name": name, "full_name": "acme/" + name})
+	}
+	if repos != nil {
+		body["repositories"] = repos
+	}
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, b.String())
+}
+
+// runCLI runs the command line against a Generator writing to buffers and returns its exit status and outputs.
+func runCLI(args []string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := NewGenerator(&stdout, &stderr).Run(args)
+	return code, stdout.String(), stderr.String()
+}
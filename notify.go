@@ -0,0 +1,85 @@
+package generatetoken
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// notifyTimeout bounds the -notify-url request so that a slow receiver cannot hold up the token.
+const notifyTimeout = 5 * time.Second
+
+// notification is the JSON body POSTed to -notify-url after an installation token is minted. It never contains the token:
+//
+//	{"app_id":123,"installation_id":42,"account":"acme","permissions":{"contents":"read"},"expires_at":"<RFC3339>"}
+//
+// app_id is 0 when the App is identified by -client-id, in which case client_id is set instead.
+type notification struct {
+	AppID          int64             `json:"app_id"`
+	ClientID       string            `json:"client_id,omitempty"`
+	InstallationID int64             `json:"installation_id"`
+	Account        string            `json:"account"`
+	Permissions    map[string]string `json:"permissions"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+}
+
+func validateNotifyURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("malformed -notify-url: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("malformed -notify-url: %s", s)
+	}
+	return nil
+}
+
+// notifyMinted reports the minted token to -notify-url. It is best effort: a failure is warned about and fails the mint only under -werror.
+func (g *Generator) notifyMinted(ctx context.Context, minted *installationToken) error {
+	if g.notifyURL == "" {
+		return nil
+	}
+	if err := g.postNotification(ctx, minted); err != nil {
+		return g.warn("failed to notify %s: %s", g.notifyURL, err)
+	}
+	g.logf("notified %s of installation %d", g.notifyURL, minted.installation.GetID())
+	return nil
+}
+
+func (g *Generator) postNotification(ctx context.Context, minted *installationToken) error {
+	perms, err := permissionsToMap(minted.token.GetPermissions())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&notification{
+		AppID:          g.appID,
+		ClientID:       g.clientID,
+		InstallationID: minted.installation.GetID(),
+		Account:        minted.installation.GetAccount().GetLogin(),
+		Permissions:    perms,
+		ExpiresAt:      minted.token.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest(): %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package generatetoken
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// Observer receives lifecycle events of token generation for metrics and tracing.
+//
+// Events carry non-secret metadata only; neither keys nor tokens are ever passed to an Observer.
+// Methods are called synchronously, so implementations should return quickly.
+// Embed NopObserver to implement only the events of interest.
+type Observer interface {
+	KeyLoaded(ctx context.Context, ev KeyLoadedEvent)
+	JWTBuilt(ctx context.Context, ev JWTBuiltEvent)
+	InstallationResolved(ctx context.Context, ev InstallationResolvedEvent)
+	TokenMinted(ctx context.Context, ev TokenMintedEvent)
+}
+
+// KeyLoadedEvent is sent after a private key is obtained.
+type KeyLoadedEvent struct {
+	// Source names where the key was read from, e.g. a file path.
+	Source   string
+	Cached   bool
+	Duration time.Duration
+	Err      error
+}
+
+// JWTBuiltEvent is sent after the app JWT is signed.
+type JWTBuiltEvent struct {
+	AppID int64
+	// Issuer is the iss claim: the App ID in decimal or the client ID given by -client-id.
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// InstallationResolvedEvent is sent after the installation for the target is looked up.
+type InstallationResolvedEvent struct {
+	InstallationID int64
+	AccountLogin   string
+	StatusCode     int
+	Duration       time.Duration
+	Err            error
+}
+
+// TokenMintedEvent is sent after an installation token is requested.
+type TokenMintedEvent struct {
+	InstallationID int64
+	ExpiresAt      time.Time
+	StatusCode     int
+	Duration       time.Duration
+	Err            error
+}
+
+// NopObserver ignores every event.
+type NopObserver struct{}
+
+var _ Observer = NopObserver{}
+
+func (NopObserver) KeyLoaded(context.Context, KeyLoadedEvent)                       {}
+func (NopObserver) JWTBuilt(context.Context, JWTBuiltEvent)                         {}
+func (NopObserver) InstallationResolved(context.Context, InstallationResolvedEvent) {}
+func (NopObserver) TokenMinted(context.Context, TokenMintedEvent)                   {}
+
+func (g *Generator) events() Observer {
+	if g.observer != nil {
+		return g.observer
+	}
+	return logObserver{g}
+}
+
+// logObserver is the CLI's default Observer feeding the verbose logger.
+type logObserver struct {
+	g *Generator
+}
+
+func (o logObserver) KeyLoaded(_ context.Context, ev KeyLoadedEvent) {
+	if ev.Err != nil {
+		return
+	}
+	o.g.logf("loaded private key %s in %s (cached=%t)", ev.Source, ev.Duration, ev.Cached)
+}
+
+func (o logObserver) JWTBuilt(_ context.Context, ev JWTBuiltEvent) {
+	if ev.Err != nil {
+		return
+	}
+	o.g.logf("signed app JWT for App %s valid until %s in %s", ev.Issuer, ev.ExpiresAt.Format(time.RFC3339), ev.Duration)
+}
+
+func (o logObserver) InstallationResolved(_ context.Context, ev InstallationResolvedEvent) {
+	if ev.Err != nil {
+		o.g.logf("installation lookup failed with status %d in %s", ev.StatusCode, ev.Duration)
+		return
+	}
+	o.g.logf("resolved installation %d on %s in %s", ev.InstallationID, ev.AccountLogin, ev.Duration)
+}
+
+func (o logObserver) TokenMinted(_ context.Context, ev TokenMintedEvent) {
+	if ev.Err != nil {
+		o.g.logf("installation token request failed with status %d in %s", ev.StatusCode, ev.Duration)
+		return
+	}
+	o.g.logf("minted installation token for installation %d valid until %s in %s", ev.InstallationID, ev.ExpiresAt.Format(time.RFC3339), ev.Duration)
+}
+
+func statusCode(resp *github.Response) int {
+	if resp == nil || resp.Response == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,131 @@
+package generatetoken
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// Option configures a Generator used as a library through GenerateInstallationToken.
+// Run takes its configuration from argv instead and overwrites whatever the options set.
+type Option func(*Generator)
+
+// WithAppID sets the ID of the GitHub App.
+func WithAppID(id int64) Option {
+	return func(g *Generator) { g.appID = id }
+}
+
+// WithClientID sets the client ID of the GitHub App to use as the JWT issuer instead of the App ID.
+func WithClientID(clientID string) Option {
+	return func(g *Generator) { g.clientID = clientID }
+}
+
+// WithPrivateKeyFile adds a private key file; keys are tried in the order they are added.
+func WithPrivateKeyFile(path string) Option {
+	return func(g *Generator) { g.privateKeys = append(g.privateKeys, fileKeySource(path)) }
+}
+
+// WithSigner adds a crypto.Signer, such as a key held by an HSM or a cloud KMS, as a private key; keys are tried in the order they are added.
+// The signer must hold an RSA key and sign with PKCS #1 v1.5 for RS256.
+// A non-empty kid is set in the JWT header, which turns on -include-kid for the other keys carrying a kid as well.
+func WithSigner(signer crypto.Signer, kid string) Option {
+	return func(g *Generator) {
+		g.privateKeys = append(g.privateKeys, &signerKeySource{key: &signingKey{signer: signer, keyID: kid}})
+		if kid != "" {
+			g.includeKID = true
+		}
+	}
+}
+
+// WithRepository sets the owner/name of the repository whose installation the token is generated for.
+func WithRepository(name string) Option {
+	return func(g *Generator) { g.installedRepository = name }
+}
+
+// WithAccount sets the organization or user whose installation the token is generated for.
+func WithAccount(login string) Option {
+	return func(g *Generator) { g.account = login }
+}
+
+// WithPermissions sets the permissions to request as name to level, e.g. "contents" to "read".
+func WithPermissions(perms map[string]string) Option {
+	return func(g *Generator) {
+		g.permissions = permissionsFlag{}
+		for name, level := range perms {
+			g.permissions[name] = level
+		}
+	}
+}
+
+// WithScopeRepositories restricts the token to the repositories given by name without the owner.
+func WithScopeRepositories(names ...string) Option {
+	return func(g *Generator) { g.scopeRepos = append(stringsFlag(nil), names...) }
+}
+
+// WithBaseURL sets the GitHub Enterprise Server API base URL.
+func WithBaseURL(baseURL string) Option {
+	return func(g *Generator) { g.baseURL = baseURL }
+}
+
+// WithLiveness sets how long the app JWT is valid for.
+func WithLiveness(d time.Duration) Option {
+	return func(g *Generator) { g.tokenLiveness = d }
+}
+
+// WithObserver registers the Observer notified of token generation events.
+// Without one, events are written as diagnostic messages under -verbose.
+func WithObserver(o Observer) Option {
+	return func(g *Generator) { g.observer = o }
+}
+
+// WithInput sets the stream read by -repo -, -revoke -, -stdin-format and the other options reading stdin; it defaults to os.Stdin.
+func WithInput(r io.Reader) Option {
+	return func(g *Generator) { g.inStream = r }
+}
+
+// WithLogger sends diagnostics to the Logger instead of the error stream given to NewGenerator.
+func WithLogger(l Logger) Option {
+	return func(g *Generator) { g.logger = l }
+}
+
+// WithTokenSink hands every token GenerateInstallationToken mints to the sink as well as returning it.
+// Without a sink the token is only returned; tokens are never written to the streams given to NewGenerator outside of Run.
+func WithTokenSink(s TokenSink) Option {
+	return func(g *Generator) { g.tokenSink = s }
+}
+
+// GenerateInstallationToken mints an installation token for the repository or account given by the options.
+//
+// It only reads the configuration fixed by NewGenerator, and parsed private keys are cached behind a mutex,
+// so one Generator may serve concurrent calls. It must not be called concurrently with Run, which overwrites the configuration from argv.
+func (g *Generator) GenerateInstallationToken(ctx context.Context) (*github.InstallationToken, error) {
+	if len(g.privateKeys) == 0 {
+		return nil, ErrMissingPrivateKey
+	}
+	if g.appID == 0 && g.clientID == "" {
+		return nil, ErrMissingAppID
+	}
+	if !g.shouldGenerateInstallationToken() {
+		return nil, errors.New("no installation target is given; use WithRepository or WithAccount")
+	}
+	for name, level := range g.permissions {
+		if !permissionLevels[level] {
+			return nil, fmt.Errorf("unknown permission level %q for %s", level, name)
+		}
+	}
+	minted, err := g.generateInstallationToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if g.tokenSink != nil {
+		if err := g.tokenSink.Put(ctx, minted.token); err != nil {
+			return nil, fmt.Errorf("TokenSink.Put(): %w", err)
+		}
+	}
+	return minted.token, nil
+}
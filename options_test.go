@@ -0,0 +1,39 @@
+package generatetoken
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestGenerateInstallationTokenConcurrently shares one Generator between goroutines; run it with -race to check the concurrency contract.
+func TestGenerateInstallationTokenConcurrently(t *testing.T) {
+	m := newMockGitHub(t)
+	g := newTestGenerator(WithBaseURL(m.URL+"/"), WithRepository("acme/api"), WithPermissions(map[string]string{"contents": "read"}))
+	const goroutines = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := g.GenerateInstallationToken(context.Background())
+			if err == nil && token.GetToken() != "ghs_mock" {
+				t.Errorf("token = %q, want ghs_mock", token.GetToken())
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mints != goroutines {
+		t.Errorf("%d tokens minted, want %d", m.mints, goroutines)
+	}
+}
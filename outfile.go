@@ -0,0 +1,70 @@
+package generatetoken
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// outFileData is the data -out-file-template is executed with:
+//
+//	{{.InstallationID}}  the installation ID, e.g. 42
+//	{{.AccountLogin}}    the login of the organization or user the App is installed on
+//
+// AccountLogin is sanitized so that it can never add a directory level or climb out of one.
+type outFileData struct {
+	InstallationID int64
+	AccountLogin   string
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func sanitizePathElement(s string) string {
+	s = unsafePathChars.ReplaceAllString(s, "_")
+	if s == "" || strings.Trim(s, ".") == "" {
+		return strings.Repeat("_", len(s)+1)
+	}
+	return s
+}
+
+func parseOutFileTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("out-file-template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -out-file-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// outFilePaths renders -out-file-template for every installation.
+// Two installations rendering the same path are rejected before any token is minted rather than letting one overwrite the other.
+func outFilePaths(tmpl *template.Template, installations []*github.Installation) ([]string, error) {
+	paths := make([]string, len(installations))
+	owners := map[string]int64{}
+	for i, installation := range installations {
+		var b strings.Builder
+		data := &outFileData{InstallationID: installation.GetID(), AccountLogin: sanitizePathElement(installation.GetAccount().GetLogin())}
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("-out-file-template: %w", err)
+		}
+		path := filepath.Clean(b.String())
+		if owner, ok := owners[path]; ok {
+			return nil, fmt.Errorf("-out-file-template renders %s for both installation %d and %d; include {{.InstallationID}} to tell them apart", path, owner, installation.GetID())
+		}
+		owners[path] = installation.GetID()
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// writeTokenFile writes the token to path with mode 0600, creating its directory with mode 0700 if needed.
+func writeTokenFile(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("os.MkdirAll(%s): %w", filepath.Dir(path), err)
+	}
+	return writeFileAtomic(path, []byte(token+"\n"))
+}
@@ -0,0 +1,383 @@
+package generatetoken
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+	// formatGitCredentials prints a line for git's credential store (~/.git-credentials):
+	//
+	//	https://x-access-token:<token>@<host>
+	//
+	// where host is github.com or the host part of -base-url.
+	formatGitCredentials = "git-credentials"
+	// formatJWTDebug prints the app JWT together with its decoded header and claims:
+	//
+	//	{"token":"<jwt>","header":{"alg":"RS256","typ":"JWT"},"claims":{...}}
+	formatJWTDebug = "jwt-debug"
+	// formatVault prints the envelope Vault's KV API expects so that the output can be POSTed as is:
+	//
+	//	{"data":{"token":"<token>","expires_at":"<RFC3339>"}}
+	//
+	// The token field is renamed by -field-name; expires_at is omitted when the expiry is unknown.
+	formatVault = "vault"
+	// formatShell prints statements to eval in a POSIX shell:
+	//
+	//	export APP_TOKEN='<token>'; export APP_TOKEN_EXPIRES_AT='<expiry>'
+	//
+	// The variable name is changed by -env-name and the expiry statement is omitted when the expiry is unknown.
+	formatShell = "shell"
+	// formatGitConfig prints a git config snippet rewriting the remote URLs of the host to HTTPS with the token embedded:
+	//
+	//	[url "https://x-access-token:<token>@<host>/"]
+	//		insteadOf = https://<host>/
+	//		insteadOf = ssh://git@<host>/
+	//		insteadOf = git@<host>:
+	//
+	// where host is github.com or the host part of -base-url. Only remotes on that host are rewritten.
+	// Write it to a file and point GIT_CONFIG_GLOBAL or include.path at it for the session rather than into a persistent config.
+	formatGitConfig = "git-config"
+	// formatJSONLines makes -batch-file and -all-installations print a JSON object per line as each token is minted
+	// instead of one document at the end. The lines come in completion order:
+	//
+	//	{"name":"<entry>","token":"<token>","expires_at":"<expiry>",...}        (-batch-file)
+	//	{"installation_id":1,"account_login":"<login>","token":"<token>",...} (-all-installations)
+	//
+	// A failure is a line with "error" in place of the token fields.
+	formatJSONLines = "jsonl"
+	// formatJWK makes -dump-public-key print the public key as a JWK instead of PEM:
+	//
+	//	{"alg":"RS256","e":"AQAB","kid":"<kid>","kty":"RSA","n":"...","use":"sig"}
+	//
+	// kid is present only when the private key carries one.
+	formatJWK = "jwk"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case formatText, formatJSON, formatGitCredentials, formatGitConfig, formatJWTDebug, formatVault, formatShell, formatJSONLines, formatJWK:
+		return nil
+	default:
+		return fmt.Errorf("unknown -format: %s", format)
+	}
+}
+
+// encodingBase64 makes -encode print the token base64-encoded (standard alphabet, padded); decoding it is up to the consumer.
+const encodingBase64 = "base64"
+
+func validateEncoding(encoding string) error {
+	switch encoding {
+	case "", encodingBase64:
+		return nil
+	default:
+		return fmt.Errorf("unknown -encode: %s", encoding)
+	}
+}
+
+// encodeToken applies -encode to the token so that every output and file written afterwards carries the encoded value.
+func (g *Generator) encodeToken(out *tokenOutput) {
+	if g.encoding == encodingBase64 {
+		out.Token = base64.StdEncoding.EncodeToString([]byte(out.Token))
+	}
+}
+
+// tokenOutput is the document printed by -format json.
+type tokenOutput struct {
+	Token          string     `json:"token,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	InstallationID int64      `json:"installation_id,omitempty"`
+	AccountLogin   string     `json:"account_login,omitempty"`
+	TargetType     string     `json:"target_type,omitempty"`
+
+	// permissions, repositories and repositorySelection are what the token was granted; they are only written to -credentials-file and -summary.
+	permissions         *github.InstallationPermissions
+	repositories        []*github.Repository
+	repositorySelection string
+}
+
+func (g *Generator) printOutput(out *tokenOutput) error {
+	if g.keychainService != "" {
+		if err := storeInKeychain(g.keychainService, g.keychainAccount, out.Token); err != nil {
+			return fmt.Errorf("storeInKeychain(): %w", err)
+		}
+		return nil
+	}
+	if g.mask {
+		// Both the installation token and the app JWT are secrets; neither contains a newline, so one command masks the whole value.
+		// The runner reads workflow commands from stderr as well, which keeps stdout to the token alone.
+		fmt.Fprintf(g.errStream, "::add-mask::%s\n", out.Token)
+	}
+	body, err := g.renderOutput(out)
+	if err != nil {
+		return err
+	}
+	if !g.noNewline {
+		body += "\n"
+	}
+	if g.outFile != "" {
+		if err := writeFileAtomic(g.outFile, []byte(body)); err != nil {
+			return err
+		}
+		if !g.tee {
+			return nil
+		}
+	}
+	fmt.Fprint(g.outStream, body)
+	return nil
+}
+
+// renderOutput renders out in the format chosen by -format and -describe.
+func (g *Generator) renderOutput(out *tokenOutput) (string, error) {
+	switch {
+	case g.describe:
+		return g.describeToken(out), nil
+	case g.outputFormat == formatJSON:
+		return marshalJSON(struct {
+			*tokenOutput
+			ExpiresAt interface{} `json:"expires_at,omitempty"`
+		}{out, g.formatExpiry(out.ExpiresAt)})
+	case g.outputFormat == formatJWTDebug:
+		debug, err := decodeJWT(out.Token)
+		if err != nil {
+			return "", err
+		}
+		return marshalJSON(debug)
+	case g.outputFormat == formatVault:
+		data := map[string]interface{}{g.vaultFieldName: out.Token}
+		if out.ExpiresAt != nil {
+			data["expires_at"] = g.formatExpiry(out.ExpiresAt)
+		}
+		return marshalJSON(map[string]interface{}{"data": data})
+	case g.outputFormat == formatShell:
+		stmts := []string{fmt.Sprintf("export %s=%s", g.envName, shellQuote(out.Token))}
+		if out.ExpiresAt != nil {
+			stmts = append(stmts, fmt.Sprintf("export %s_EXPIRES_AT=%s", g.envName, shellQuote(fmt.Sprint(g.formatExpiry(out.ExpiresAt)))))
+		}
+		return strings.Join(stmts, "; "), nil
+	case g.outputFormat == formatGitCredentials:
+		return (&url.URL{Scheme: "https", User: url.UserPassword("x-access-token", out.Token), Host: g.gitHost()}).String(), nil
+	case g.outputFormat == formatGitConfig:
+		return gitConfigSnippet(g.gitHost(), out.Token), nil
+	default:
+		return out.Token, nil
+	}
+}
+
+func gitConfigSnippet(host, token string) string {
+	base := (&url.URL{Scheme: "https", User: url.UserPassword("x-access-token", token), Host: host, Path: "/"}).String()
+	lines := []string{
+		fmt.Sprintf("[url %q]", base),
+		"\tinsteadOf = https://" + host + "/",
+		"\tinsteadOf = ssh://git@" + host + "/",
+		"\tinsteadOf = git@" + host + ":",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote quotes s in single quotes; embedded single quotes are closed, escaped and reopened so that no value can break out of the quoting.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func marshalJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal(): %w", err)
+	}
+	return string(b), nil
+}
+
+const (
+	expiryFormatRFC3339  = "rfc3339"
+	expiryFormatUnix     = "unix"
+	expiryFormatRelative = "relative"
+)
+
+func validateExpiryFormat(format string) error {
+	switch format {
+	case expiryFormatRFC3339, expiryFormatUnix, expiryFormatRelative:
+		return nil
+	default:
+		return fmt.Errorf("unknown -expiry-format: %s", format)
+	}
+}
+
+// formatExpiry renders t as chosen by -expiry-format: an RFC3339 string, epoch seconds, or the remaining duration such as "59m58s".
+// It returns nil when t is nil so that JSON outputs omit the field.
+func (g *Generator) formatExpiry(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	switch g.expiryFormat {
+	case expiryFormatUnix:
+		return t.Unix()
+	case expiryFormatRelative:
+		return time.Until(*t).Round(time.Second).String()
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// describeToken summarizes the token without revealing it, e.g. "length=40 prefix=ghs_ expires_at=... token=ghs_********".
+func (g *Generator) describeToken(out *tokenOutput) string {
+	prefix := tokenPrefix(out.Token)
+	fields := []string{fmt.Sprintf("length=%d", len(out.Token))}
+	if prefix != "" {
+		fields = append(fields, "prefix="+prefix)
+	}
+	if out.ExpiresAt != nil {
+		fields = append(fields, fmt.Sprintf("expires_at=%v", g.formatExpiry(out.ExpiresAt)))
+	}
+	fields = append(fields, "token="+prefix+strings.Repeat("*", 8))
+	return strings.Join(fields, " ")
+}
+
+// summarize describes what was minted in one line for -summary, e.g.
+//
+//	minted installation token for Organization acme (installation 42), scope: selected repositories [api], permissions: contents:read, expires 2030-01-01T00:00:00Z (in 59m59s)
+//
+// It is built from the API response and never includes the token.
+func (g *Generator) summarize(out *tokenOutput) (string, error) {
+	var b strings.Builder
+	if out.InstallationID == 0 {
+		fmt.Fprintf(&b, "signed app JWT for App %s", g.issuer())
+	} else {
+		fmt.Fprintf(&b, "minted installation token for %s %s (installation %d)", out.TargetType, out.AccountLogin, out.InstallationID)
+		if out.repositorySelection == repositorySelectionSelected || len(out.repositories) > 0 {
+			names := make([]string, 0, len(out.repositories))
+			for _, repo := range out.repositories {
+				names = append(names, repo.GetName())
+			}
+			fmt.Fprintf(&b, ", scope: selected repositories [%s]", strings.Join(names, ", "))
+		} else {
+			b.WriteString(", scope: all repositories")
+		}
+		perms, err := permissionsToMap(out.permissions)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, ", permissions: %s", formatPermissions(perms))
+	}
+	if out.ExpiresAt != nil {
+		fmt.Fprintf(&b, ", expires %s (in %s)", out.ExpiresAt.Format(time.RFC3339), time.Until(*out.ExpiresAt).Round(time.Second))
+	}
+	return b.String(), nil
+}
+
+// reportOnExit prints the -report-on-exit line, e.g.
+//
+//	done: minted installation token for Organization acme (installation 42), scope: all repositories, ...; token delivered to /run/token
+//
+// It is the last line written to stderr on success, for pipelines that only keep that much of the log, and never includes the token.
+func (g *Generator) reportOnExit(out *tokenOutput, command bool) error {
+	if !g.reportOnExitLine {
+		return nil
+	}
+	line, err := g.summarize(out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(g.errStream, "done: %s; token delivered to %s\n", line, strings.Join(g.tokenDestinations(command), ", "))
+	return nil
+}
+
+// tokenDestinations names where the token was delivered in a -report-on-exit line.
+func (g *Generator) tokenDestinations(command bool) []string {
+	if command {
+		return []string{"the command's " + execTokenEnv}
+	}
+	var dests []string
+	switch {
+	case g.keychainService != "":
+		dests = append(dests, "keychain service "+g.keychainService)
+	case g.outFile != "":
+		dests = append(dests, g.outFile)
+		if g.tee {
+			dests = append(dests, "stdout")
+		}
+	default:
+		dests = append(dests, "stdout")
+	}
+	if g.credentialsFile != "" {
+		dests = append(dests, g.credentialsFile)
+	}
+	if g.gitHubOutput {
+		dests = append(dests, "$GITHUB_OUTPUT")
+	}
+	return dests
+}
+
+// tokenPrefix returns the type prefix GitHub puts on its tokens (ghs_, ghu_, ...) or an empty string for tokens without one such as the app JWT.
+func tokenPrefix(token string) string {
+	if i := strings.IndexByte(token, '_'); i > 0 && i <= 4 {
+		return token[:i+1]
+	}
+	return ""
+}
+
+type jwtDebug struct {
+	Token  string          `json:"token"`
+	Header json.RawMessage `json:"header"`
+	Claims json.RawMessage `json:"claims"`
+}
+
+// decodeJWT decodes the header and claims segments of a compact JWT as they were signed.
+func decodeJWT(token string) (*jwtDebug, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("malformed JWT: want 3 segments, got %d", len(segments))
+	}
+	header, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT header: %w", err)
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT claims: %w", err)
+	}
+	return &jwtDebug{Token: token, Header: header, Claims: claims}, nil
+}
+
+// writeRawResponse appends the create-installation-token response body to -raw-response, pretty-printed and with the token replaced by its prefix and asterisks.
+// Every field GitHub sent is kept, including those go-github does not know.
+func (g *Generator) writeRawResponse(body []byte) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+	if token, ok := doc["token"].(string); ok {
+		doc["token"] = tokenPrefix(token) + strings.Repeat("*", 8)
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent(): %w", err)
+	}
+	b = append(b, '\n')
+	if g.rawResponse == "-" {
+		_, err := g.errStream.Write(b)
+		return err
+	}
+	f, err := os.OpenFile(g.rawResponse, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(%s): %w", g.rawResponse, err)
+	}
+	defer f.Close()
+	// One write per document keeps the documents of concurrent batch mints from interleaving.
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("failed to write %s: %w", g.rawResponse, err)
+	}
+	return nil
+}
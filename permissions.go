@@ -0,0 +1,255 @@
+package generatetoken
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+)
+
+var permissionLevels = map[string]bool{"read": true, "write": true, "admin": true}
+
+// permissionRank orders the levels so that a granted level can be checked to cover a requested one.
+var permissionRank = map[string]int{"read": 1, "write": 2, "admin": 3}
+
+// builtinProfiles holds the permission profiles available to -permission-profile without -profiles-file.
+//
+//go:embed profiles.json
+var builtinProfiles []byte
+
+// loadPermissionProfile resolves the named profile from the built-in profiles and, if given, profilesFile.
+// Profiles defined in profilesFile take precedence over built-in ones of the same name.
+func loadPermissionProfile(name, profilesFile string) (map[string]string, error) {
+	var profiles map[string]map[string]string
+	if err := json.Unmarshal(builtinProfiles, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse built-in profiles: %w", err)
+	}
+	if profilesFile != "" {
+		b, err := ioutil.ReadFile(profilesFile)
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", profilesFile, err)
+		}
+		var custom map[string]map[string]string
+		if err := json.Unmarshal(b, &custom); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", profilesFile, err)
+		}
+		for n, p := range custom {
+			profiles[n] = p
+		}
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown permission profile: %s", name)
+	}
+	for perm, level := range profile {
+		if !permissionLevels[level] {
+			return nil, fmt.Errorf("permission profile %s: unknown permission level %q for %s", name, level, perm)
+		}
+	}
+	return profile, nil
+}
+
+// permissionsFlag collects repeated -permission name=level values; a later value for the same name wins.
+type permissionsFlag map[string]string
+
+func (f permissionsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, name := range sortedPermissionNames(f) {
+		pairs = append(pairs, name+"="+f[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sortedPermissionNames returns the permission names in m in lexical order so that anything printed from a permission map is stable.
+func sortedPermissionNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatPermissions renders m as "contents:read, issues:write" in lexical order.
+func formatPermissions(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for _, name := range sortedPermissionNames(m) {
+		pairs = append(pairs, name+":"+m[name])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (f permissionsFlag) Set(v string) error {
+	name, level, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("malformed permission %q; want name=level", v)
+	}
+	if !permissionLevels[level] {
+		return fmt.Errorf("unknown permission level %q for %s; want one of read, write, admin", level, name)
+	}
+	f[name] = level
+	return nil
+}
+
+// requestedPermissions builds the permissions to request for the installation token.
+//
+// Permissions from -token-options-json form the lowest layer, presets are applied next (-read-only, then -permission-profile)
+// and explicit -permission entries are applied on top of them,
+// so `-read-only -permission contents=write` requests contents:write and read access to everything else.
+// It returns nil when nothing is requested so that the token inherits all of the installation's permissions.
+func (g *Generator) requestedPermissions(installation *github.Installation) (*github.InstallationPermissions, error) {
+	perms := map[string]string{}
+	if g.rawTokenOptions != nil {
+		base, err := permissionsToMap(g.rawTokenOptions.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		for name, level := range base {
+			perms[name] = level
+		}
+	}
+	if g.readOnly {
+		granted, err := permissionsToMap(installation.GetPermissions())
+		if err != nil {
+			return nil, err
+		}
+		for name := range granted {
+			perms[name] = "read"
+		}
+	}
+	for name, level := range g.profilePermissions {
+		perms[name] = level
+	}
+	for name, level := range g.permissions {
+		perms[name] = level
+	}
+	if len(perms) == 0 {
+		return nil, nil
+	}
+	g.logf("requesting permissions: %s", formatPermissions(perms))
+	return permissionsFromMap(perms)
+}
+
+func permissionsToMap(perms *github.InstallationPermissions) (map[string]string, error) {
+	m := map[string]string{}
+	if perms == nil {
+		return m, nil
+	}
+	b, err := json.Marshal(perms)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(): %w", err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+	return m, nil
+}
+
+func permissionsFromMap(m map[string]string) (*github.InstallationPermissions, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(): %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var perms github.InstallationPermissions
+	if err := dec.Decode(&perms); err != nil {
+		return nil, fmt.Errorf("unknown permission: %w", err)
+	}
+	return &perms, nil
+}
+
+// implicitPermissions are granted to every installation token whether or not they are requested.
+var implicitPermissions = map[string]bool{"metadata": true}
+
+// verifyGrantedScope compares the minted token against what was requested.
+//
+// A token requested for specific repositories must come back with repository_selection "selected", and its repositories must match the requested set exactly.
+// Each requested permission must be granted at the requested level, and no permission other than the implicit ones may be granted beyond the request.
+// GitHub answers with the installation's whole permission set when none is requested, so permissions are only compared when some were requested.
+func verifyGrantedScope(opts *github.InstallationTokenOptions, token *mintedToken) error {
+	var problems []string
+	if (len(opts.Repositories) > 0 || len(opts.RepositoryIDs) > 0) && token.RepositorySelection != repositorySelectionSelected {
+		problems = append(problems, fmt.Sprintf("repository selection is %q although specific repositories were requested", token.RepositorySelection))
+	}
+	if len(opts.Repositories) > 0 {
+		requested := map[string]bool{}
+		for _, name := range opts.Repositories {
+			requested[strings.ToLower(name)] = true
+		}
+		granted := map[string]bool{}
+		for _, repo := range token.Repositories {
+			name := strings.ToLower(repo.GetName())
+			granted[name] = true
+			if !requested[name] {
+				problems = append(problems, fmt.Sprintf("repository %s was granted but not requested", repo.GetName()))
+			}
+		}
+		for _, name := range opts.Repositories {
+			if !granted[strings.ToLower(name)] {
+				problems = append(problems, fmt.Sprintf("repository %s was requested but not granted", name))
+			}
+		}
+	}
+	if opts.Permissions != nil {
+		requested, err := permissionsToMap(opts.Permissions)
+		if err != nil {
+			return err
+		}
+		granted, err := permissionsToMap(token.Permissions)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedPermissionNames(requested) {
+			level := requested[name]
+			got, ok := granted[name]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("permission %s=%s was requested but not granted", name, level))
+			case got != level:
+				problems = append(problems, fmt.Sprintf("permission %s was requested as %s but granted as %s", name, level, got))
+			}
+		}
+		for _, name := range sortedPermissionNames(granted) {
+			if _, ok := requested[name]; !ok && !implicitPermissions[name] {
+				problems = append(problems, fmt.Sprintf("permission %s=%s was granted but not requested", name, granted[name]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("minted token does not match the requested scope:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// preflightPermissions checks that the installation holds every requested permission at the requested level or higher.
+// GitHub silently drops permissions the installation lacks, so this reports them before a token is minted without them.
+func preflightPermissions(requested, granted *github.InstallationPermissions) error {
+	want, err := permissionsToMap(requested)
+	if err != nil {
+		return err
+	}
+	have, err := permissionsToMap(granted)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, name := range sortedPermissionNames(want) {
+		got, ok := have[name]
+		switch {
+		case !ok:
+			missing = append(missing, fmt.Sprintf("%s:%s (not granted)", name, want[name]))
+		case permissionRank[got] < permissionRank[want[name]]:
+			missing = append(missing, fmt.Sprintf("%s:%s (granted %s)", name, want[name], got))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the installation lacks requested permissions: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
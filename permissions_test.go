@@ -0,0 +1,125 @@
+package generatetoken
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+)
+
+func TestRequestedPermissions(t *testing.T) {
+	installation := &github.Installation{
+		Permissions: &github.InstallationPermissions{
+			Contents: github.String("write"),
+			Issues:   github.String("write"),
+			Metadata: github.String("read"),
+		},
+	}
+	cases := []struct {
+		name         string
+		tokenOptions *github.InstallationTokenOptions
+		readOnly     bool
+		profile      map[string]string
+		permissions  permissionsFlag
+		want         map[string]string
+	}{
+		{
+			name: "nothing requested inherits the installation",
+			want: nil,
+		},
+		{
+			name:     "read-only downgrades every granted permission",
+			readOnly: true,
+			want:     map[string]string{"contents": "read", "issues": "read", "metadata": "read"},
+		},
+		{
+			name:        "permission overrides read-only",
+			readOnly:    true,
+			permissions: permissionsFlag{"contents": "write"},
+			want:        map[string]string{"contents": "write", "issues": "read", "metadata": "read"},
+		},
+		{
+			name:     "profile overrides read-only",
+			readOnly: true,
+			profile:  map[string]string{"issues": "write"},
+			want:     map[string]string{"contents": "read", "issues": "write", "metadata": "read"},
+		},
+		{
+			name:        "permission overrides profile",
+			profile:     map[string]string{"contents": "read", "pull_requests": "write"},
+			permissions: permissionsFlag{"pull_requests": "read"},
+			want:        map[string]string{"contents": "read", "pull_requests": "read"},
+		},
+		{
+			name:         "token options are the lowest layer",
+			tokenOptions: &github.InstallationTokenOptions{Permissions: &github.InstallationPermissions{Contents: github.String("write"), Checks: github.String("read")}},
+			readOnly:     true,
+			permissions:  permissionsFlag{"checks": "write"},
+			want:         map[string]string{"checks": "write", "contents": "read", "issues": "read", "metadata": "read"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &Generator{rawTokenOptions: tc.tokenOptions, readOnly: tc.readOnly, profilePermissions: tc.profile, permissions: tc.permissions}
+			perms, err := g.requestedPermissions(installation)
+			if err != nil {
+				t.Fatalf("requestedPermissions(): %s", err)
+			}
+			if tc.want == nil {
+				if perms != nil {
+					t.Errorf("requestedPermissions() = %v, want nil", perms)
+				}
+				return
+			}
+			got, err := permissionsToMap(perms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("requestedPermissions() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStrictRepositorySelection(t *testing.T) {
+	cases := []struct {
+		name      string
+		selection string
+		strict    bool
+		wantCode  int
+		wantErr   string
+	}{
+		{name: "selected as requested", selection: "selected", strict: true, wantCode: 0},
+		{name: "all despite selected under -strict", selection: "all", strict: true, wantCode: 1, wantErr: `repository selection is "all" although specific repositories were requested`},
+		{name: "all despite selected without -strict", selection: "all", wantCode: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			m.mint = func(w http.ResponseWriter, r *http.Request, opts *github.InstallationTokenOptions) {
+				mintToken(w, r, opts, tc.selection)
+			}
+			args := m.args("-repo", "acme/api", "-scope-repo", "api")
+			if tc.strict {
+				args = append(args, "-strict")
+			}
+			code, stdout, stderr := runCLI(args)
+			if code != tc.wantCode {
+				t.Fatalf("Run() = %d, want %d: %s", code, tc.wantCode, stderr)
+			}
+			if tc.wantErr != "" {
+				if !strings.Contains(stderr, tc.wantErr) {
+					t.Errorf("stderr = %q, want it to contain %q", stderr, tc.wantErr)
+				}
+				if stdout != "" {
+					t.Errorf("stdout = %q, want the token withheld", stdout)
+				}
+			} else if stdout != "ghs_mock\n" {
+				t.Errorf("stdout = %q, want the token", stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package generatetoken
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// envAllowedRepos is read for -allowed-repos when the flag is not given, so that a shared CI runner can pin the list for every job.
+const envAllowedRepos = "GITHUB_APP_ALLOWED_REPOS"
+
+// repoAllowList is the parsed -allowed-repos: owner/name entries and owner/* entries covering every repository of the owner, all lowercased.
+type repoAllowList []string
+
+// parseRepoAllowList parses the comma-separated elements of every -allowed-repos value.
+func parseRepoAllowList(values []string) (repoAllowList, error) {
+	var list repoAllowList
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			elem = strings.TrimSpace(elem)
+			if elem == "" {
+				continue
+			}
+			owner, name, found := strings.Cut(elem, "/")
+			if !found || owner == "" || name == "" || strings.Contains(owner, "*") || (strings.Contains(name, "*") && name != "*") {
+				return nil, fmt.Errorf("malformed -allowed-repos entry %q; want owner/name or owner/*", elem)
+			}
+			list = append(list, strings.ToLower(elem))
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("-allowed-repos is empty")
+	}
+	return list, nil
+}
+
+// allows reports whether owner/name is in the list; name "*" asks whether every repository of the owner is.
+func (l repoAllowList) allows(owner, name string) bool {
+	owner, name = strings.ToLower(owner), strings.ToLower(name)
+	for _, entry := range l {
+		o, n, _ := strings.Cut(entry, "/")
+		if o == owner && (n == "*" || n == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAllowedRepos takes -allowed-repos, or GITHUB_APP_ALLOWED_REPOS when the flag is not given.
+func (g *Generator) resolveAllowedRepos(passed map[string]bool) error {
+	values := []string(g.allowedRepoValues)
+	if !passed["allowed-repos"] {
+		if os.Getenv(envAllowedRepos) == "" {
+			return nil
+		}
+		values = []string{os.Getenv(envAllowedRepos)}
+	}
+	list, err := parseRepoAllowList(values)
+	if err != nil {
+		return err
+	}
+	g.allowedRepos = list
+	return nil
+}
+
+// checkAllowedTargets refuses to mint unless the -repo target and every repository the token is scoped to are in -allowed-repos.
+// Scoped repositories belong to the installation's account. A token without -scope-repo covers every repository of the installation,
+// even when named by -repo, so it needs an owner/* entry for the account; repository IDs cannot be checked at all.
+func (g *Generator) checkAllowedTargets(installation *github.Installation, opts *github.InstallationTokenOptions) error {
+	if g.allowedRepos == nil {
+		return nil
+	}
+	if len(opts.RepositoryIDs) > 0 {
+		return errors.New("repository_ids cannot be checked against -allowed-repos; scope the token by name instead")
+	}
+	if g.installedRepository != "" {
+		owner, name, _ := strings.Cut(g.installedRepository, "/")
+		if !g.allowedRepos.allows(owner, name) {
+			return fmt.Errorf("%s is not in -allowed-repos", g.installedRepository)
+		}
+	}
+	owner := installation.GetAccount().GetLogin()
+	for _, name := range opts.Repositories {
+		if !g.allowedRepos.allows(owner, name) {
+			return fmt.Errorf("%s/%s is not in -allowed-repos", owner, name)
+		}
+	}
+	if len(opts.Repositories) == 0 && !g.allowedRepos.allows(owner, "*") {
+		return fmt.Errorf("%s/* is not in -allowed-repos; restrict the token to allowed repositories with -scope-repo", owner)
+	}
+	return nil
+}
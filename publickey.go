@@ -0,0 +1,49 @@
+package generatetoken
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// dumpPublicKey prints the public key of the first private key, as a PEM "PUBLIC KEY" block or, with -format jwk, as a JWK
+// carrying the kid of the private key if it has one. It needs neither the App ID nor the network.
+func (g *Generator) dumpPublicKey() error {
+	key, _, err := g.loadKey(g.privateKeys[0])
+	if err != nil {
+		return err
+	}
+	pub := key.signer.Public()
+	if g.outputFormat == formatJWK {
+		jwkKey, err := jwk.FromRaw(pub)
+		if err != nil {
+			return fmt.Errorf("jwk.FromRaw(): %w", err)
+		}
+		if key.keyID != "" {
+			if err := jwkKey.Set(jwk.KeyIDKey, key.keyID); err != nil {
+				return fmt.Errorf("jwk.Key.Set(): %w", err)
+			}
+		}
+		if err := jwkKey.Set(jwk.AlgorithmKey, jwa.RS256); err != nil {
+			return fmt.Errorf("jwk.Key.Set(): %w", err)
+		}
+		if err := jwkKey.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+			return fmt.Errorf("jwk.Key.Set(): %w", err)
+		}
+		b, err := json.Marshal(jwkKey)
+		if err != nil {
+			return fmt.Errorf("json.Marshal(): %w", err)
+		}
+		fmt.Fprintln(g.outStream, string(b))
+		return nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("x509.MarshalPKIXPublicKey(): %w", err)
+	}
+	return pem.Encode(g.outStream, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
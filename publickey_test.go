@@ -0,0 +1,36 @@
+package generatetoken
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDumpPublicKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		fixture string
+	}{
+		// The fixtures were derived from testdata/key.pem with openssl rather than by this command,
+		// key.pub.pem by `openssl pkey -pubout` and key.pub.jwk from the modulus `openssl rsa -modulus` prints.
+		{name: "PEM", fixture: "testdata/key.pub.pem"},
+		{name: "JWK", args: []string{"-format", "jwk"}, fixture: "testdata/key.pub.jwk"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := ioutil.ReadFile(tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(envAppID, "")
+			args := append([]string{"generate-github-app-token", "-dump-public-key", "-private-key", testKeyFile}, tc.args...)
+			code, stdout, stderr := runCLI(args)
+			if code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr)
+			}
+			if stdout != string(want) {
+				t.Errorf("stdout = %q, want %q", stdout, want)
+			}
+		})
+	}
+}
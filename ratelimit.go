@@ -0,0 +1,67 @@
+package generatetoken
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// rateLimitOutput is a resource of the -probe-rate-limit output.
+type rateLimitOutput struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+func newRateLimitOutput(rate *github.Rate) *rateLimitOutput {
+	if rate == nil {
+		return nil
+	}
+	return &rateLimitOutput{Limit: rate.Limit, Remaining: rate.Remaining, Reset: rate.Reset.Time}
+}
+
+// probeRateLimit prints the rate limits GitHub applies to requests authenticated by the app JWT.
+// -min-rate-limit is compared against the core resource, which the token minting requests count against.
+func (g *Generator) probeRateLimit(ctx context.Context) error {
+	client, err := g.appClient(ctx)
+	if err != nil {
+		return err
+	}
+	limits, _, err := client.RateLimits(ctx)
+	if err != nil {
+		return fmt.Errorf("RateLimits(): %w", classifyAPIError(err))
+	}
+	resources := []struct {
+		name string
+		rate *github.Rate
+	}{
+		{"core", limits.GetCore()},
+		{"search", limits.GetSearch()},
+		{"graphql", limits.GetGraphQL()},
+	}
+	if g.outputFormat == formatJSON {
+		out := map[string]*rateLimitOutput{}
+		for _, r := range resources {
+			if r.rate != nil {
+				out[r.name] = newRateLimitOutput(r.rate)
+			}
+		}
+		body, err := marshalJSON(out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(g.outStream, body)
+	} else {
+		for _, r := range resources {
+			if r.rate != nil {
+				fmt.Fprintf(g.outStream, "%s: %d/%d remaining, resets at %s\n", r.name, r.rate.Remaining, r.rate.Limit, r.rate.Reset.Time.Format(time.RFC3339))
+			}
+		}
+	}
+	if core := limits.GetCore(); g.minRateLimit > 0 && core != nil && core.Remaining < g.minRateLimit {
+		return fmt.Errorf("only %d core requests remain, below -min-rate-limit %d; the limit resets at %s", core.Remaining, g.minRateLimit, core.Reset.Format(time.RFC3339))
+	}
+	return nil
+}
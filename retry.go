@@ -0,0 +1,155 @@
+package generatetoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+const (
+	backoffConstant    = "constant"
+	backoffExponential = "exponential"
+	backoffJitter      = "jitter"
+)
+
+// backoff decides how long to wait before the next attempt.
+type backoff interface {
+	// delay returns the wait after the n-th failed attempt; n starts at 1.
+	delay(n int) time.Duration
+}
+
+// constantBackoff waits the same duration after every attempt.
+type constantBackoff struct {
+	base time.Duration
+}
+
+func (b constantBackoff) delay(int) time.Duration {
+	return b.base
+}
+
+// exponentialBackoff doubles the wait after every attempt up to max.
+type exponentialBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func (b exponentialBackoff) delay(n int) time.Duration {
+	d := b.base
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= b.max {
+			return b.max
+		}
+	}
+	if d > b.max {
+		return b.max
+	}
+	return d
+}
+
+// jitterBackoff is the "full jitter" strategy: a random wait between zero and the exponential delay.
+type jitterBackoff struct {
+	exponentialBackoff
+	rand *rand.Rand
+}
+
+func (b jitterBackoff) delay(n int) time.Duration {
+	d := b.exponentialBackoff.delay(n)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(b.rand.Int63n(int64(d) + 1))
+}
+
+func newBackoff(strategy string, base, max time.Duration) (backoff, error) {
+	if base < 0 || max < 0 {
+		return nil, fmt.Errorf("retry delays must not be negative: -retry-delay=%s -max-retry-delay=%s", base, max)
+	}
+	switch strategy {
+	case backoffConstant:
+		return constantBackoff{base: base}, nil
+	case backoffExponential:
+		return exponentialBackoff{base: base, max: max}, nil
+	case backoffJitter:
+		return jitterBackoff{exponentialBackoff: exponentialBackoff{base: base, max: max}, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	default:
+		return nil, fmt.Errorf("unknown -backoff: %s", strategy)
+	}
+}
+
+// withAttempts calls f until it succeeds or -attempts are used up, waiting between attempts as -backoff decides.
+// Every retry draws on -retry-budget, and once the budget is used up the first failure of any call is returned.
+// Only errors retryable reports true for are retried. It stops early when ctx is done, e.g. by -timeout, and then returns the last error of f.
+//
+// Lookups pass isTransient. Creating an installation token is not idempotent, as a response lost on the way back still leaves a live token behind,
+// so the mint passes neverSent and is retried only when the request provably did not leave this host.
+func (g *Generator) withAttempts(ctx context.Context, retryable func(error) bool, f func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = f(ctx); err == nil {
+			return nil
+		}
+		if attempt >= g.attempts || !retryable(err) {
+			return err
+		}
+		if !g.retries.take(g.retryBudget) {
+			return fmt.Errorf("-retry-budget of %d retries is used up: %w", g.retryBudget, err)
+		}
+		delay := g.backoff.delay(attempt)
+		g.logf("attempt %d of %d failed: %s; retrying in %s", attempt, g.attempts, err, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryBudget counts the retries made against -retry-budget over the whole run.
+// It is shared by reference so that Generators cloned for batch entries and installations draw on the same budget.
+type retryBudget struct {
+	mu   sync.Mutex
+	used int
+}
+
+// take reports whether another retry fits in limit and counts it if so; a limit of 0 or less is unlimited.
+func (b *retryBudget) take(limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used >= limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// isTransient reports whether err may go away by itself: a network error or a 5xx response, GitHub's maintenance mode included.
+// A malformed repository, a suspended installation or a rejected key fails the same way every time and is not worth retrying.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.Response != nil && errResp.Response.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// neverSent reports whether err means the request never reached GitHub: the connection could not be made, DNS resolution included.
+// Anything later, a reset connection or a 5xx among them, is ambiguous about whether GitHub acted on the request.
+func neverSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,136 @@
+package generatetoken
+
+import (
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelays(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy string
+		base     time.Duration
+		max      time.Duration
+		want     []time.Duration
+	}{
+		{
+			name:     "constant ignores max",
+			strategy: backoffConstant,
+			base:     time.Second,
+			max:      time.Millisecond,
+			want:     []time.Duration{time.Second, time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "exponential doubles up to max",
+			strategy: backoffExponential,
+			base:     time.Second,
+			max:      5 * time.Second,
+			want:     []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:     "exponential with base above max",
+			strategy: backoffExponential,
+			base:     10 * time.Second,
+			max:      3 * time.Second,
+			want:     []time.Duration{3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:     "exponential without delay",
+			strategy: backoffExponential,
+			want:     []time.Duration{0, 0, 0},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := newBackoff(tc.strategy, tc.base, tc.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []time.Duration
+			for n := 1; n <= len(tc.want); n++ {
+				got = append(got, b.delay(n))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("delays = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJitterBackoffStaysWithinExponential(t *testing.T) {
+	exp := exponentialBackoff{base: time.Second, max: 8 * time.Second}
+	b := jitterBackoff{exponentialBackoff: exp, rand: rand.New(rand.NewSource(1))}
+	for n := 1; n <= 6; n++ {
+		for i := 0; i < 100; i++ {
+			if d := b.delay(n); d < 0 || d > exp.delay(n) {
+				t.Fatalf("delay(%d) = %s, want within [0, %s]", n, d, exp.delay(n))
+			}
+		}
+	}
+}
+
+func TestNewBackoffRejects(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy string
+		base     time.Duration
+		max      time.Duration
+	}{
+		{name: "unknown strategy", strategy: "linear", base: time.Second, max: time.Second},
+		{name: "negative base", strategy: backoffConstant, base: -time.Second},
+		{name: "negative max", strategy: backoffExponential, base: time.Second, max: -time.Second},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := newBackoff(tc.strategy, tc.base, tc.max); err == nil {
+				t.Error("newBackoff() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	cases := []struct {
+		name        string
+		args        []string
+		batch       int
+		wantLookups int
+		wantErr     string
+	}{
+		{name: "-attempts alone", args: []string{"-attempts", "3"}, wantLookups: 3},
+		{name: "budget below -attempts", args: []string{"-attempts", "5", "-retry-budget", "1"}, wantLookups: 2, wantErr: "-retry-budget of 1 retries is used up"},
+		{name: "budget above -attempts", args: []string{"-attempts", "3", "-retry-budget", "10"}, wantLookups: 3},
+		{name: "batch without a budget", args: []string{"-attempts", "3"}, batch: 3, wantLookups: 9},
+		{name: "batch entries share the budget", args: []string{"-attempts", "3", "-retry-budget", "2"}, batch: 3, wantLookups: 5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			m.lookup = func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusBadGateway, map[string]string{"message": "Server Error"})
+			}
+			args := append([]string{"generate-github-app-token", "-base-url", m.URL + "/", "-retry-delay", "0", "-concurrency", "1"}, tc.args...)
+			if tc.batch > 0 {
+				args = append(args, "-batch-file", writeBatchFile(t, tc.batch, "acme/api"))
+			} else {
+				args = append(args, "-id", "123", "-private-key", testKeyFile, "-repo", "acme/api")
+			}
+			code, _, stderr := runCLI(args)
+			if code != 1 {
+				t.Fatalf("Run() = %d, want 1: %s", code, stderr)
+			}
+			if tc.wantErr != "" && !strings.Contains(stderr, tc.wantErr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tc.wantErr)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.lookups != tc.wantLookups {
+				t.Errorf("%d installation lookups, want %d", m.lookups, tc.wantLookups)
+			}
+		})
+	}
+}
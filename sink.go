@@ -0,0 +1,29 @@
+package generatetoken
+
+import (
+	"context"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// Logger receives the diagnostics a Generator would otherwise print to its error stream, so that embedders can route them to their logging framework.
+//
+// Infof gets the messages -verbose prints; they are sent whether or not verbose output is enabled and left to the Logger to filter.
+// Warnf gets the warnings that are always printed. Both may be called from concurrent GenerateInstallationToken calls. The method set matches the sugared loggers of common logging libraries such as zap and logrus.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// TokenSink receives every installation token GenerateInstallationToken mints, before the token is returned to the caller.
+// An error from Put is returned by GenerateInstallationToken instead of the token.
+type TokenSink interface {
+	Put(ctx context.Context, token *github.InstallationToken) error
+}
+
+// TokenSinkFunc adapts a function to TokenSink.
+type TokenSinkFunc func(ctx context.Context, token *github.InstallationToken) error
+
+func (f TokenSinkFunc) Put(ctx context.Context, token *github.InstallationToken) error {
+	return f(ctx, token)
+}
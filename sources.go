@@ -0,0 +1,152 @@
+package generatetoken
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Configuration sources, from the highest precedence to the lowest.
+// Each field is resolved on its own: the first source that sets it wins and the rest are ignored for that field.
+const (
+	sourceFlag   = "flag"
+	sourceEnv    = "env"
+	sourceConfig = "config"
+	// sourceGHConfig is the gh CLI's hosts.yml, read for the base URL only and only with -from-gh-config.
+	sourceGHConfig = "gh-config"
+	sourceDefault  = "default"
+)
+
+// Environment variables read for the fields below when the corresponding flag is not given.
+const (
+	envAppID      = "GITHUB_APP_ID"
+	envPrivateKey = "GITHUB_APP_PRIVATE_KEY_FILE"
+	envKeyID      = "GITHUB_APP_KID"
+	envBaseURL    = "GITHUB_APP_BASE_URL"
+	// envRepository is set by GitHub Actions to the owner/name of the workflow's repository; it is only read with -repo-from-env.
+	envRepository = "GITHUB_REPOSITORY"
+)
+
+// configFile is the document read by -config.
+//
+//	{"app_id": 123, "private_key": "/path/to/key.pem", "kid": "k1", "base_url": "https://ghe.example.com/api/v3/"}
+type configFile struct {
+	AppID      int64  `json:"app_id"`
+	PrivateKey string `json:"private_key"`
+	KeyID      string `json:"kid"`
+	BaseURL    string `json:"base_url"`
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%s): %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var cfg configFile
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid -config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// resolveSources fills the App ID, private key, kid and base URL from the environment and -config for every one of them not given as a flag,
+// and the repository from GITHUB_REPOSITORY under -repo-from-env unless -repo or -account is given,
+// following the precedence flag > env > config > default, with gh's hosts.yml before the default base URL under -from-gh-config, and records the winning source of each field for -print-config.
+func (g *Generator) resolveSources(passed map[string]bool) error {
+	cfg := &configFile{}
+	if g.configPath != "" {
+		loaded, err := loadConfigFile(g.configPath)
+		if err != nil {
+			return err
+		}
+		cfg = loaded
+	}
+	g.sources = map[string]string{}
+
+	switch {
+	case passed["id"] || passed["id-fd"] || passed["app-slug"] || passed["client-id"]:
+		g.sources["app_id"] = sourceFlag
+	case os.Getenv(envAppID) != "":
+		id, err := strconv.ParseInt(os.Getenv(envAppID), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", envAppID, err)
+		}
+		g.appID = id
+		g.sources["app_id"] = sourceEnv
+	case cfg.AppID != 0:
+		g.appID = cfg.AppID
+		g.sources["app_id"] = sourceConfig
+	default:
+		g.sources["app_id"] = sourceDefault
+	}
+
+	switch {
+	case passed["private-key"] || passed["private-key-fd"]:
+		g.sources["private_keys"] = sourceFlag
+	case os.Getenv(envPrivateKey) != "":
+		source, err := newKeySource(os.Getenv(envPrivateKey), g.keyFetchClient)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", envPrivateKey, err)
+		}
+		g.privateKeys = keySources{source}
+		g.sources["private_keys"] = sourceEnv
+	case cfg.PrivateKey != "":
+		source, err := newKeySource(cfg.PrivateKey, g.keyFetchClient)
+		if err != nil {
+			return fmt.Errorf("invalid private_key in -config %s: %w", g.configPath, err)
+		}
+		g.privateKeys = keySources{source}
+		g.sources["private_keys"] = sourceConfig
+	default:
+		g.sources["private_keys"] = sourceDefault
+	}
+
+	switch {
+	case passed["repo"] || passed["account"]:
+		g.sources["repo"] = sourceFlag
+	case g.repoFromEnv:
+		repo := os.Getenv(envRepository)
+		if repo == "" {
+			return fmt.Errorf("-repo-from-env is set but %s is empty", envRepository)
+		}
+		g.installedRepository = repo
+		g.sources["repo"] = sourceEnv
+	default:
+		g.sources["repo"] = sourceDefault
+	}
+
+	g.sources["kid"] = resolveString(&g.keyID, passed["kid"], envKeyID, cfg.KeyID)
+	g.sources["base_url"] = resolveString(&g.baseURL, passed["base-url"], envBaseURL, cfg.BaseURL)
+	if g.fromGHConfig && g.sources["base_url"] == sourceDefault {
+		baseURL, err := g.baseURLFromGHConfig()
+		if err != nil {
+			return err
+		}
+		if baseURL != "" {
+			g.baseURL = baseURL
+			g.sources["base_url"] = sourceGHConfig
+		}
+	}
+	return nil
+}
+
+// resolveString sets *v from the environment variable or the config value unless the flag was given, and returns the source that won.
+func resolveString(v *string, flagPassed bool, envName, configValue string) string {
+	switch {
+	case flagPassed:
+		return sourceFlag
+	case os.Getenv(envName) != "":
+		*v = os.Getenv(envName)
+		return sourceEnv
+	case configValue != "":
+		*v = configValue
+		return sourceConfig
+	default:
+		return sourceDefault
+	}
+}
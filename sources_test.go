@@ -0,0 +1,197 @@
+package generatetoken
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResolveSourcesPrecedence(t *testing.T) {
+	const config = `{"app_id": 3, "private_key": "config.pem", "kid": "config-kid", "base_url": "https://config.example.com/api/v3/"}`
+	cases := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		config     bool
+		field      string
+		wantValue  string
+		wantSource string
+	}{
+		{
+			name:       "app_id from flag over env and config",
+			args:       []string{"-id", "1"},
+			env:        map[string]string{envAppID: "2"},
+			config:     true,
+			field:      "app_id",
+			wantValue:  "1",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "app_id from env over config",
+			env:        map[string]string{envAppID: "2"},
+			config:     true,
+			field:      "app_id",
+			wantValue:  "2",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "app_id from config",
+			config:     true,
+			field:      "app_id",
+			wantValue:  "3",
+			wantSource: sourceConfig,
+		},
+		{
+			name:       "private key from flag over env and config",
+			args:       []string{"-private-key", "flag.pem"},
+			env:        map[string]string{envPrivateKey: "env.pem"},
+			config:     true,
+			field:      "private_keys",
+			wantValue:  "flag.pem",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "private key from env over config",
+			env:        map[string]string{envPrivateKey: "env.pem"},
+			config:     true,
+			field:      "private_keys",
+			wantValue:  "env.pem",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "private key from config",
+			config:     true,
+			field:      "private_keys",
+			wantValue:  "config.pem",
+			wantSource: sourceConfig,
+		},
+		{
+			name:       "kid from flag over env and config",
+			args:       []string{"-kid", "flag-kid"},
+			env:        map[string]string{envKeyID: "env-kid"},
+			config:     true,
+			field:      "kid",
+			wantValue:  "flag-kid",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "kid from env over config",
+			env:        map[string]string{envKeyID: "env-kid"},
+			config:     true,
+			field:      "kid",
+			wantValue:  "env-kid",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "kid from config",
+			config:     true,
+			field:      "kid",
+			wantValue:  "config-kid",
+			wantSource: sourceConfig,
+		},
+		{
+			name:       "kid by default",
+			field:      "kid",
+			wantValue:  "",
+			wantSource: sourceDefault,
+		},
+		{
+			name:       "base URL from flag over env and config",
+			args:       []string{"-base-url", "https://flag.example.com/api/v3/"},
+			env:        map[string]string{envBaseURL: "https://env.example.com/api/v3/"},
+			config:     true,
+			field:      "base_url",
+			wantValue:  "https://flag.example.com/api/v3/",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "base URL from env over config",
+			env:        map[string]string{envBaseURL: "https://env.example.com/api/v3/"},
+			config:     true,
+			field:      "base_url",
+			wantValue:  "https://env.example.com/api/v3/",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "base URL from config",
+			config:     true,
+			field:      "base_url",
+			wantValue:  "https://config.example.com/api/v3/",
+			wantSource: sourceConfig,
+		},
+		{
+			name:       "base URL by default",
+			field:      "base_url",
+			wantValue:  "https://api.github.com/",
+			wantSource: sourceDefault,
+		},
+		{
+			name:       "repo from flag over GITHUB_REPOSITORY",
+			args:       []string{"-repo", "flag/repo", "-repo-from-env"},
+			env:        map[string]string{envRepository: "env/repo"},
+			field:      "repo",
+			wantValue:  "flag/repo",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "repo from GITHUB_REPOSITORY with -repo-from-env",
+			args:       []string{"-repo-from-env"},
+			env:        map[string]string{envRepository: "env/repo"},
+			field:      "repo",
+			wantValue:  "env/repo",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "GITHUB_REPOSITORY ignored without -repo-from-env",
+			env:        map[string]string{envRepository: "env/repo"},
+			field:      "repo",
+			wantValue:  "",
+			wantSource: sourceDefault,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{envAppID, envPrivateKey, envKeyID, envBaseURL, envRepository, envAllowedRepos} {
+				t.Setenv(name, tc.env[name])
+			}
+			args := []string{"generate-github-app-token", "-print-config"}
+			if tc.config {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", path)
+			} else {
+				// app_id and private_keys are required; give them so that the other fields can fall back to their defaults.
+				args = append(args, "-id", "9", "-private-key", "default.pem")
+			}
+			args = append(args, tc.args...)
+			var stdout, stderr bytes.Buffer
+			g := NewGenerator(&stdout, &stderr)
+			if code := g.Run(args); code != 0 {
+				t.Fatalf("Run() = %d: %s", code, stderr.String())
+			}
+			var cfg effectiveConfig
+			if err := json.Unmarshal(stdout.Bytes(), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]string{
+				"app_id":       strconv.FormatInt(cfg.AppID, 10),
+				"private_keys": strings.Join(cfg.PrivateKeys, ","),
+				"kid":          cfg.KeyID,
+				"base_url":     cfg.BaseURL,
+				"repo":         cfg.Repository,
+			}
+			if got := values[tc.field]; got != tc.wantValue {
+				t.Errorf("%s = %q, want %q", tc.field, got, tc.wantValue)
+			}
+			if got := cfg.Sources[tc.field]; got != tc.wantSource {
+				t.Errorf("sources[%s] = %q, want %q", tc.field, got, tc.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package generatetoken
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// runState is the -since-last-run state file: the credentials document of the last minted token and the request it answers.
+// A token is reused only for the very same request, so changing the target, the permissions or the repositories mints a new one.
+type runState struct {
+	Request string `json:"request"`
+	credentialsDocument
+}
+
+// stateRequest identifies what the token was minted for.
+func (g *Generator) stateRequest() string {
+	return strings.Join([]string{
+		g.installationCacheKey(),
+		"permissions=" + formatPermissions(g.permissions),
+		"profile=" + g.permissionProfile,
+		"scope=" + strings.Join(g.scopeRepos, ","),
+		"read-only=" + fmt.Sprint(g.readOnly),
+		"options=" + g.tokenOptionsJSON,
+	}, " ")
+}
+
+// loadState returns the token of the last run when it answers the same request and stays valid beyond -refresh-margin and -require-remaining.
+// A missing, corrupt or stale state file is not an error but, for the latter two, a warning: a new token is minted and the file is rewritten.
+func (g *Generator) loadState(now time.Time) (*tokenOutput, error) {
+	b, err := ioutil.ReadFile(g.stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, g.warn("ignoring -since-last-run state: %s", err)
+	}
+	var state runState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, g.warn("ignoring corrupt -since-last-run state %s: %s", g.stateFile, err)
+	}
+	margin := g.refreshMargin
+	if g.requireRemaining > margin {
+		margin = g.requireRemaining
+	}
+	switch {
+	case state.Request != g.stateRequest():
+		g.logf("the last token in %s was minted for another request", g.stateFile)
+		return nil, nil
+	case state.Token == "" || state.ExpiresAt == nil:
+		return nil, g.warn("ignoring incomplete -since-last-run state %s", g.stateFile)
+	case !state.ExpiresAt.After(now.Add(margin)):
+		g.logf("the last token in %s expires at %s, within %s", g.stateFile, state.ExpiresAt.Format(time.RFC3339), margin)
+		return nil, nil
+	}
+	out := &tokenOutput{
+		Token:          state.Token,
+		ExpiresAt:      state.ExpiresAt,
+		InstallationID: state.InstallationID,
+		AccountLogin:   state.AccountLogin,
+		TargetType:     state.TargetType,
+	}
+	if perms, err := permissionsFromMap(state.Permissions); err == nil {
+		out.permissions = perms
+	}
+	for _, name := range state.Repositories {
+		out.repositories = append(out.repositories, &github.Repository{FullName: github.String(name), Name: github.String(name[strings.Index(name, "/")+1:])})
+	}
+	g.logf("reusing the token of the last run valid until %s", state.ExpiresAt.Format(time.RFC3339))
+	return out, nil
+}
+
+// checkReusedToken looks the installation up again and applies the checks a mint would,
+// so that a token saved before -allowed-repos was tightened or the installation was suspended is not handed out.
+func (g *Generator) checkReusedToken(ctx context.Context) error {
+	client, err := g.appClient(ctx)
+	if err != nil {
+		return err
+	}
+	installation, _, err := g.findInstallation(ctx, client)
+	if err != nil {
+		return err
+	}
+	opts, err := g.installationTokenOptions(installation)
+	if err != nil {
+		return err
+	}
+	return g.checkInstallation(installation, opts)
+}
+
+// saveState records the minted token for the next run with mode 0600.
+func (g *Generator) saveState(out *tokenOutput) error {
+	doc, err := newCredentialsDocument(out)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(&runState{Request: g.stateRequest(), credentialsDocument: *doc}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent(): %w", err)
+	}
+	return writeFileAtomic(g.stateFile, append(b, '\n'))
+}
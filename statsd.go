@@ -0,0 +1,82 @@
+package generatetoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// statsdTimeout bounds resolving -statsd-addr and sending the packet so that metrics never hold up the run.
+const statsdTimeout = time.Second
+
+// StatsD metrics sent by -statsd-addr in one packet at the end of the run:
+//
+//	generate_github_app_token.run.success:1|c                  the run succeeded
+//	generate_github_app_token.run.failure.<category>:1|c       the run failed; see failureCategory for the categories
+//	generate_github_app_token.run.duration:<milliseconds>|ms   how long the run took, whether it succeeded or not
+const statsdPrefix = "generate_github_app_token.run."
+
+// failureCategory sorts the error of a run into one of unauthorized, not_found, maintenance, validation, rate_limited, no_access, timeout and other.
+func failureCategory(err error) string {
+	var (
+		rateLimit   *github.RateLimitError
+		abuse       *github.AbuseRateLimitError
+		maintenance *maintenanceError
+		validation  *validationError
+		noAccess    *noAccessError
+	)
+	switch {
+	case errors.As(err, &maintenance):
+		return "maintenance"
+	case errors.As(err, &validation):
+		return "validation"
+	case errors.As(err, &noAccess):
+		return "no_access"
+	case errors.As(err, &rateLimit), errors.As(err, &abuse):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case isUnauthorized(err):
+		return "unauthorized"
+	case isNotFound(err):
+		return "not_found"
+	default:
+		return "other"
+	}
+}
+
+// sendStatsD sends the metrics of the run to -statsd-addr over UDP. It is best effort: failures are only logged.
+func (g *Generator) sendStatsD(runErr error, elapsed time.Duration) {
+	lines := []string{fmt.Sprintf("%sduration:%d|ms", statsdPrefix, elapsed.Milliseconds())}
+	if runErr == nil {
+		lines = append(lines, statsdPrefix+"success:1|c")
+	} else {
+		lines = append(lines, statsdPrefix+"failure."+failureCategory(runErr)+":1|c")
+	}
+	conn, err := net.DialTimeout("udp", g.statsdAddr, statsdTimeout)
+	if err != nil {
+		g.logf("failed to send metrics to %s: %s", g.statsdAddr, err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(statsdTimeout)); err != nil {
+		g.logf("failed to send metrics to %s: %s", g.statsdAddr, err)
+		return
+	}
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		g.logf("failed to send metrics to %s: %s", g.statsdAddr, err)
+	}
+}
+
+// validateStatsDAddr checks that -statsd-addr is a host:port.
+func validateStatsDAddr(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid -statsd-addr %q: %w", addr, err)
+	}
+	return nil
+}
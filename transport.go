@@ -0,0 +1,138 @@
+package generatetoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// headerTransport sets header on every request before handing it to base, replacing the values go-github has set.
+type headerTransport struct {
+	header http.Header
+	base   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the caller's request.
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+const (
+	ipVersionAuto = "auto"
+	ipVersion4    = "4"
+	ipVersion6    = "6"
+)
+
+func validateIPVersion(version string) error {
+	switch version {
+	case ipVersionAuto, ipVersion4, ipVersion6:
+		return nil
+	default:
+		return fmt.Errorf("unknown -ip-version: %s", version)
+	}
+}
+
+// dialingClient returns a client whose connections use only the address family chosen by -ip-version, or nil to use the default transport.
+func (g *Generator) dialingClient() *http.Client {
+	if g.ipVersion == "" || g.ipVersion == ipVersionAuto {
+		return nil
+	}
+	network := "tcp" + g.ipVersion
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// requestLogTransport logs every API call with the request ID GitHub assigned to it, so that each attempt can be traced in GitHub's logs.
+type requestLogTransport struct {
+	g    *Generator
+	base http.RoundTripper
+}
+
+func (t *requestLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.g.logf("%s %s failed: %s", req.Method, req.URL.Path, err)
+		return nil, err
+	}
+	t.g.logf("%s %s: %d (%s: %s)", req.Method, req.URL.Path, resp.StatusCode, headerRequestID, resp.Header.Get(headerRequestID))
+	return resp, nil
+}
+
+// wrapTransport returns httpClient with the custom headers applied and the calls logged; httpClient may be nil.
+func (g *Generator) wrapTransport(httpClient *http.Client) *http.Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := g.extraHeaders.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if g.accept != "" {
+		header.Set("Accept", g.accept)
+	}
+	if len(header) > 0 {
+		base = &headerTransport{header: header, base: base}
+	}
+	wrapped := *httpClient
+	wrapped.Transport = &requestLogTransport{g: g, base: base}
+	return &wrapped
+}
+
+// headerNamePattern matches the token characters RFC 7230 allows in a header name.
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// headersFlag collects -header "Name: Value"; repeating a name sends every value.
+type headersFlag http.Header
+
+func (f headersFlag) String() string {
+	return fmt.Sprint(http.Header(f))
+}
+
+func (f headersFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || !headerNamePattern.MatchString(name) {
+		return fmt.Errorf("malformed header %q; want Name: Value", v)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("malformed header %q: the value contains a line break", v)
+	}
+	// The token is sent in Authorization; letting a header replace it would send the request unauthenticated or as someone else.
+	if http.CanonicalHeaderKey(name) == "Authorization" {
+		return errors.New("-header cannot set Authorization")
+	}
+	http.Header(f).Add(name, value)
+	return nil
+}
+
+// validateAccept checks that every comma-separated element of -accept is a media type such as application/vnd.github+json.
+func validateAccept(accept string) error {
+	for _, elem := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(elem))
+		if err != nil {
+			return fmt.Errorf("invalid -accept %q: %w", accept, err)
+		}
+		if !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("invalid -accept %q: %s is not a media type", accept, mediaType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package generatetoken
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+type flagGroup struct {
+	title string
+	// note tells which flags of the group cannot be combined.
+	note  string
+	flags []string
+}
+
+// flagGroups orders the flags in the usage message. A flag missing here is listed under "Other options" so that none is hidden.
+var flagGroups = []flagGroup{
+	{
+		title: "App identity",
+		note:  "-id, -id-fd and -client-id are mutually exclusive ways to name the App. -app-slug looks the App ID up instead, or with -id or -id-fd checks that the slug belongs to that App.",
+		flags: []string{"id", "id-fd", "app-slug", "client-id", "config", "stdin-format"},
+	},
+	{
+		title: "Private key",
+		note:  "-private-key and -private-key-fd may be repeated and mixed; keys are tried in order.",
+		flags: []string{"private-key", "private-key-fd", "kid", "include-kid", "min-key-bits", "strict-key", "verify-app", "verify-jwt"},
+	},
+	{
+		title: "Installation target",
+		note:  "-repo and -account are mutually exclusive; give neither to print the app JWT. -batch-file and -all-installations replace them.",
+		flags: []string{"repo", "repo-from-env", "account", "require-installation-token", "allow-suspended", "no-network", "batch-file", "all-installations", "out-file-template", "concurrency", "fail-fast"},
+	},
+	{
+		title: "Token scope",
+		flags: []string{"permission", "read-only", "permission-profile", "profiles-file", "scope-repo", "max-repos", "token-options-json", "preflight-permissions", "strict", "verify-access", "allowed-repos"},
+	},
+	{
+		title: "App JWT claims",
+		note:  "-iat and -exp cannot be combined with -liveness. -jwt-claim and -jwt-typ are meant for mock servers and may break authentication with GitHub.",
+		flags: []string{"liveness", "iat", "exp", "minimal-claims", "jwt-claim", "jwt-typ"},
+	},
+	{
+		title: "Output",
+		flags: []string{"format", "encode", "no-newline", "expiry-format", "env-name", "field-name", "describe", "summary", "report-on-exit", "mask", "github-output", "out-file", "tee", "credentials-file", "since-last-run", "keychain-service", "keychain-account", "notify-url"},
+	},
+	{
+		title: "Retries",
+		flags: []string{"attempts", "retry-budget", "timeout", "backoff", "retry-delay", "max-retry-delay"},
+	},
+	{
+		title: "GitHub API",
+		flags: []string{"base-url", "from-gh-config", "header", "accept", "ip-version"},
+	},
+	{
+		title: "Freshness check",
+		note:  "-check-expiry exits 0 when the token is fresh, 3 when it needs refreshing and 2 when the timestamp is malformed. -require-remaining also exits 3.",
+		flags: []string{"check-expiry", "refresh-margin", "require-remaining"},
+	},
+	{
+		title: "Inspection",
+		flags: []string{"show-permissions", "show-single-file", "dump-public-key", "probe-rate-limit", "min-rate-limit", "compare-with", "print-config", "validate-config"},
+	},
+	{
+		title: "User-to-server token",
+		flags: []string{"refresh-user-token"},
+		note:  "-refresh-user-token prints the access token and, when GitHub rotates it, the new refresh token on the next line; -format json prints both with their expiry.",
+	},
+	{
+		title: "Revocation",
+		flags: []string{"revoke", "print-revoke-cmd"},
+	},
+	{
+		title: "Diagnostics",
+		flags: []string{"verbose", "log-file", "werror", "color", "statsd-addr", "raw-response"},
+	},
+}
+
+const usageExamples = `Examples:
+  # installation token for a repository
+  %[1]s -id 123 -private-key key.pem -repo owner/repo
+  # installation token for every repository of an organization or user
+  %[1]s -id 123 -private-key key.pem -account owner
+  # app JWT only
+  %[1]s -id 123 -private-key key.pem
+  # run a command with the token in GITHUB_TOKEN and revoke the token when it exits
+  %[1]s -id 123 -private-key key.pem -repo owner/repo -- git push
+`
+
+// usage prints the flags of fset grouped by flagGroups followed by examples.
+func usage(fset *flag.FlagSet) func() {
+	return func() {
+		w := fset.Output()
+		fmt.Fprintf(w, "Usage of %s:\n", fset.Name())
+		listed := map[string]bool{}
+		for _, group := range flagGroups {
+			fmt.Fprintf(w, "\n%s:\n", group.title)
+			if group.note != "" {
+				fmt.Fprintf(w, "  (%s)\n", group.note)
+			}
+			for _, name := range group.flags {
+				if f := fset.Lookup(name); f != nil {
+					listed[name] = true
+					fmt.Fprint(w, formatFlag(f))
+				}
+			}
+		}
+		var others []*flag.Flag
+		fset.VisitAll(func(f *flag.Flag) {
+			if !listed[f.Name] {
+				others = append(others, f)
+			}
+		})
+		if len(others) > 0 {
+			fmt.Fprint(w, "\nOther options:\n")
+			for _, f := range others {
+				fmt.Fprint(w, formatFlag(f))
+			}
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, usageExamples, fset.Name())
+	}
+}
+
+// formatFlag renders f like flag.PrintDefaults does.
+func formatFlag(f *flag.Flag) string {
+	var b strings.Builder
+	name, usage := flag.UnquoteUsage(f)
+	fmt.Fprintf(&b, "  -%s", f.Name)
+	if name != "" {
+		fmt.Fprintf(&b, " %s", name)
+	}
+	b.WriteString("\n    \t")
+	b.WriteString(strings.ReplaceAll(usage, "\n", "\n    \t"))
+	switch f.DefValue {
+	case "", "0", "false", "0s", "[]", "map[]":
+	default:
+		if name == "string" {
+			fmt.Fprintf(&b, " (default %q)", f.DefValue)
+		} else {
+			fmt.Fprintf(&b, " (default %v)", f.DefValue)
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package generatetoken
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// envClientSecret holds the App's OAuth client secret for -refresh-user-token; it is read from the environment to keep it out of argv.
+const envClientSecret = "GITHUB_APP_CLIENT_SECRET"
+
+// userTokenOutput is the document -refresh-user-token prints with -format json.
+// GitHub rotates the refresh token on every refresh, so the new one must be stored in place of the one read from stdin.
+type userTokenOutput struct {
+	Token                 string     `json:"token"`
+	ExpiresAt             *time.Time `json:"expires_at,omitempty"`
+	RefreshToken          string     `json:"refresh_token"`
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+}
+
+// oauthTokenURL returns the OAuth token endpoint on github.com or on the GitHub Enterprise Server host of -base-url.
+func (g *Generator) oauthTokenURL() string {
+	scheme := "https"
+	if g.baseURL != "" {
+		if u, err := url.Parse(g.baseURL); err == nil {
+			scheme = u.Scheme
+		}
+	}
+	return (&url.URL{Scheme: scheme, Host: g.gitHost(), Path: "/login/oauth/access_token"}).String()
+}
+
+// refreshUserToken exchanges the refresh token read from stdin for a new user-to-server token of the App given by -client-id.
+// This flow authenticates with the client ID and the client secret in GITHUB_APP_CLIENT_SECRET; the private key plays no part in it.
+func (g *Generator) refreshUserToken(ctx context.Context) error {
+	if g.clientID == "" {
+		return errors.New("-refresh-user-token requires -client-id")
+	}
+	secret := os.Getenv(envClientSecret)
+	if secret == "" {
+		return fmt.Errorf("-refresh-user-token requires the client secret in %s", envClientSecret)
+	}
+	if g.outputFormat != formatText && g.outputFormat != formatJSON {
+		return fmt.Errorf("-refresh-user-token supports -format text or json only: %s", g.outputFormat)
+	}
+	line, err := bufio.NewReader(g.inStream).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("failed to read the refresh token from stdin: %w", err)
+	}
+	refreshToken := strings.TrimSpace(line)
+	if refreshToken == "" {
+		return errors.New("-refresh-user-token reads the refresh token from stdin but it was empty")
+	}
+	cfg := &oauth2.Config{
+		ClientID:     g.clientID,
+		ClientSecret: secret,
+		Endpoint:     oauth2.Endpoint{TokenURL: g.oauthTokenURL(), AuthStyle: oauth2.AuthStyleInParams},
+	}
+	if httpClient := g.dialingClient(); httpClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+	token, err := cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return fmt.Errorf("failed to refresh the user token: %w", err)
+	}
+	g.logf("refreshed the user-to-server token of App %s", g.clientID)
+	if g.outputFormat == formatText {
+		// Both tokens are printed before any warning can fail the run under -werror: the old refresh token is spent by now.
+		fmt.Fprintln(g.outStream, token.AccessToken)
+		if token.RefreshToken == refreshToken {
+			return nil
+		}
+		fmt.Fprintln(g.outStream, token.RefreshToken)
+		return g.warn("GitHub issued a new refresh token, printed on the second line; the one given is no longer valid")
+	}
+	out := &userTokenOutput{Token: token.AccessToken, RefreshToken: token.RefreshToken}
+	if !token.Expiry.IsZero() {
+		out.ExpiresAt = &token.Expiry
+	}
+	if secs, ok := token.Extra("refresh_token_expires_in").(float64); ok && secs > 0 {
+		expiresAt := time.Now().Add(time.Duration(secs) * time.Second)
+		out.RefreshTokenExpiresAt = &expiresAt
+	}
+	body, err := marshalJSON(out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(g.outStream, body)
+	return nil
+}
@@ -0,0 +1,195 @@
+package generatetoken
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// validateConfig resolves the configuration from flags, the environment and -config, and checks it as a whole without talking to GitHub.
+// Unlike a normal run it does not stop at the first problem: every problem is printed, one per line, so that a CI check can report them at once.
+func (g *Generator) validateConfig(passed map[string]bool, command []string) error {
+	var problems []error
+	if err := g.resolveSources(passed); err != nil {
+		problems = append(problems, err)
+	}
+	if err := g.resolveAllowedRepos(passed); err != nil {
+		problems = append(problems, err)
+	}
+	problems = append(problems, g.checkConfig(passed, command)...)
+	for _, source := range g.privateKeys {
+		if _, _, err := g.loadKey(source); err != nil {
+			problems = append(problems, fmt.Errorf("private key %s: %w", source, err))
+		}
+	}
+	if g.batchFile != "" {
+		if _, err := loadBatchEntries(g.batchFile); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(g.outStream, "configuration is valid")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(g.outStream, "- %s\n", problem)
+	}
+	return fmt.Errorf("%d problems found in the configuration", len(problems))
+}
+
+// checkConfig checks the resolved flags against each other without talking to GitHub and returns every problem found, in flag order.
+// run stops at the first one and -validate-config reports them all, so that the two never disagree.
+// The parsed -token-options-json and -permission-profile are kept on the Generator so that they are read once.
+func (g *Generator) checkConfig(passed map[string]bool, command []string) []error {
+	var problems []error
+	check := func(failed bool, err error) {
+		if failed {
+			problems = append(problems, err)
+		}
+	}
+	batch := g.batchFile != "" || g.allInstallations
+	// -revoke and -refresh-user-token authenticate with the token they are given, not as the App.
+	asApp := g.revokeTarget == "" && !g.refreshUserTokenOnly
+	single := asApp && !batch && !g.dumpPublicKeyOnly
+
+	check((passed["iat"] || passed["exp"]) && passed["liveness"], errors.New("-iat and -exp cannot be combined with -liveness"))
+	check(g.minimalClaims && len(g.jwtClaims) > 0, errors.New("-minimal-claims cannot be combined with -jwt-claim"))
+	check(g.attempts < 1, fmt.Errorf("-attempts must be at least 1: %d", g.attempts))
+	check(g.retryBudget < 0, fmt.Errorf("-retry-budget must not be negative: %d", g.retryBudget))
+	if _, err := newBackoff(g.backoffStrategy, g.retryDelay, g.maxRetryDelay); err != nil {
+		problems = append(problems, err)
+	}
+	check(g.installedRepository != "" && g.account != "", errors.New("-repo and -account cannot be combined"))
+	if g.clientID != "" {
+		check(passed["id"] || passed["id-fd"] || passed["app-slug"], errors.New("-client-id cannot be combined with -id, -id-fd or -app-slug"))
+		check(!clientIDPattern.MatchString(g.clientID), fmt.Errorf("malformed -client-id: %s", g.clientID))
+	}
+	check(g.appIDFD >= 0 && passed["id"], errors.New("-id and -id-fd cannot be combined"))
+	check(g.concurrency < 1, fmt.Errorf("-concurrency must be at least 1: %d", g.concurrency))
+	if g.noNetwork {
+		if err := g.checkNoNetwork(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	check(asApp && g.batchFile == "" && len(g.privateKeys) == 0, ErrMissingPrivateKey)
+	check(asApp && g.batchFile == "" && !g.dumpPublicKeyOnly && g.appID == 0 && g.appIDFD < 0 && g.appSlug == "" && g.clientID == "", ErrMissingAppID)
+	check(g.allInstallations && g.shouldGenerateInstallationToken(), errors.New("-all-installations cannot be combined with -repo or -account"))
+
+	if err := validateFormat(g.outputFormat); err != nil {
+		problems = append(problems, err)
+	}
+	if g.dumpPublicKeyOnly {
+		check(g.outputFormat != formatText && g.outputFormat != formatJWK, fmt.Errorf("-dump-public-key prints PEM or, with -format jwk, a JWK; -format %s is not available", g.outputFormat))
+	} else {
+		check(g.outputFormat == formatJWK, errors.New("-format jwk is only available with -dump-public-key"))
+	}
+	check(g.outputFormat == formatJSONLines && !batch, errors.New("-format jsonl is only available with -batch-file and -all-installations"))
+	if err := validateEncoding(g.encoding); err != nil {
+		problems = append(problems, err)
+	}
+	check(g.encoding != "" && g.outputFormat == formatJWTDebug, errors.New("-encode cannot be combined with -format jwt-debug"))
+	for _, err := range []error{
+		validateColorMode(g.colorMode),
+		validateExpiryFormat(g.expiryFormat),
+		validateIPVersion(g.ipVersion),
+	} {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	check(passed["repo"] && strings.TrimSpace(g.installedRepository) == "", errors.New("repo was set empty; did an environment variable fail to expand?"))
+	check(g.vaultFieldName == "" || g.vaultFieldName == "expires_at", fmt.Errorf("invalid -field-name: %q", g.vaultFieldName))
+	check(!envNamePattern.MatchString(g.envName), fmt.Errorf("invalid -env-name: %q", g.envName))
+	if g.tokenOptionsJSON != "" {
+		opts, err := parseTokenOptionsJSON(g.tokenOptionsJSON)
+		if err != nil {
+			problems = append(problems, err)
+		}
+		g.rawTokenOptions = opts
+	}
+	if g.permissionProfile != "" {
+		profile, err := loadPermissionProfile(g.permissionProfile, g.profilesFile)
+		if err != nil {
+			problems = append(problems, err)
+		}
+		g.profilePermissions = profile
+	}
+	if g.maxRepos > 0 {
+		scoped := g.scopedTokenOptions()
+		n := len(scoped.Repositories) + len(scoped.RepositoryIDs)
+		check(n > g.maxRepos, fmt.Errorf("the token would be scoped to %d repositories by -scope-repo and -token-options-json but -max-repos is %d", n, g.maxRepos))
+	}
+	check(g.keychainService != "" && g.keychainAccount == "", errors.New("-keychain-account is required with -keychain-service"))
+	if single && !g.shouldGenerateInstallationToken() {
+		check(g.requireInstallation, errors.New("-require-installation-token is set but no installation target is given; specify -repo or -account"))
+		check(g.outputFormat == formatGitCredentials || g.outputFormat == formatGitConfig, fmt.Errorf("-format %s requires an installation token; specify -repo or -account", g.outputFormat))
+		check(g.verifyAccess != "", errors.New("-verify-access requires an installation token; specify -repo or -account"))
+		check(g.requireRemaining > 0, errors.New("-require-remaining requires an installation token; specify -repo or -account"))
+		check(g.stateFile != "", errors.New("-since-last-run requires an installation token; specify -repo or -account"))
+		check(g.compareWith != "", errors.New("-compare-with requires -repo or -account"))
+		check(g.showPermissionsOnly, errors.New("-show-permissions requires -repo or -account"))
+		check(g.showSingleFileOnly, errors.New("-show-single-file requires -repo or -account"))
+	}
+	check(g.outputFormat == formatJWTDebug && g.shouldGenerateInstallationToken(), errors.New("-format jwt-debug is only available for the app token; do not specify -repo or -account"))
+	if g.baseURL != "" {
+		if u, err := url.Parse(g.baseURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("malformed -base-url: %s", g.baseURL))
+		}
+	}
+	check(g.stateFile != "" && command != nil, errors.New("-since-last-run cannot be combined with a command after --, which revokes the token"))
+	check(g.minRateLimit != 0 && !g.probeRateLimitOnly, errors.New("-min-rate-limit requires -probe-rate-limit"))
+	check(g.compareWith != "" && (command != nil || g.stateFile != ""), errors.New("-compare-with cannot be combined with a command after -- or -since-last-run"))
+	check(g.outFileTemplate != "" && !g.allInstallations, errors.New("-out-file-template requires -all-installations"))
+	check(g.tee && g.outFile == "", errors.New("-tee requires -out-file"))
+	if g.statsdAddr != "" {
+		if err := validateStatsDAddr(g.statsdAddr); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if g.accept != "" {
+		if err := validateAccept(g.accept); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if g.notifyURL != "" {
+		if err := validateNotifyURL(g.notifyURL); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// checkNoNetwork lists the options that would make a network call despite -no-network.
+func (g *Generator) checkNoNetwork() error {
+	var conflicts []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"-repo", g.installedRepository != ""},
+		{"-account", g.account != ""},
+		{"-app-slug", g.appSlug != ""},
+		{"-all-installations", g.allInstallations},
+		{"-batch-file", g.batchFile != ""},
+		{"-verify-app", g.verifyApp},
+		{"-verify-access", g.verifyAccess != ""},
+		{"-show-permissions", g.showPermissionsOnly},
+		{"-show-single-file", g.showSingleFileOnly},
+		{"-compare-with", g.compareWith != ""},
+		{"-probe-rate-limit", g.probeRateLimitOnly},
+		{"-notify-url", g.notifyURL != ""},
+		{"-revoke", g.revokeTarget != ""},
+		{"-refresh-user-token", g.refreshUserTokenOnly},
+		{"-private-key https://", g.privateKeys.fetchesOverNetwork()},
+		{"-statsd-addr", g.statsdAddr != ""},
+	} {
+		if opt.set {
+			conflicts = append(conflicts, opt.name)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("-no-network cannot be combined with options that call GitHub: %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
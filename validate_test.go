@@ -0,0 +1,45 @@
+package generatetoken
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxRepos(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "-scope-repo within the limit", args: []string{"-max-repos", "2", "-scope-repo", "a", "-scope-repo", "b"}},
+		{name: "-scope-repo over the limit", args: []string{"-max-repos", "1", "-scope-repo", "a", "-scope-repo", "b"}, wantErr: "scoped to 2 repositories"},
+		{name: "-token-options-json repositories within the limit", args: []string{"-max-repos", "3", "-token-options-json", `{"repositories": ["a", "b", "c"]}`}},
+		{name: "-token-options-json repositories over the limit", args: []string{"-max-repos", "1", "-token-options-json", `{"repositories": ["a", "b", "c"]}`}, wantErr: "scoped to 3 repositories"},
+		{name: "-token-options-json repository_ids over the limit", args: []string{"-max-repos", "1", "-token-options-json", `{"repository_ids": [1, 2]}`}, wantErr: "scoped to 2 repositories"},
+		{name: "-token-options-json repositories and repository_ids together", args: []string{"-max-repos", "2", "-token-options-json", `{"repositories": ["a"], "repository_ids": [1, 2]}`}, wantErr: "scoped to 3 repositories"},
+		{name: "-scope-repo replaces -token-options-json repositories", args: []string{"-max-repos", "1", "-scope-repo", "a", "-token-options-json", `{"repositories": ["a", "b", "c"]}`}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGitHub(t)
+			code, stdout, stderr := runCLI(m.args(append([]string{"-repo", "acme/api"}, tc.args...)...))
+			if tc.wantErr == "" {
+				if code != 0 {
+					t.Fatalf("Run() = %d: %s", code, stderr)
+				}
+				return
+			}
+			if code == 0 || !strings.Contains(stderr, tc.wantErr) {
+				t.Fatalf("Run() = %d with stderr %q, want a failure with %q", code, stderr, tc.wantErr)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want the token withheld", stdout)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.mints != 0 {
+				t.Errorf("%d tokens minted, want none", m.mints)
+			}
+		})
+	}
+}